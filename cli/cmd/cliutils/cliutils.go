@@ -2,25 +2,15 @@ package cliutils
 
 import (
 	"barbe/core"
-	"barbe/core/aws_session_provider"
-	"barbe/core/buildkit_runner"
 	"barbe/core/chown_util"
 	"barbe/core/fetcher"
-	"barbe/core/gcp_token_provider"
 	"barbe/core/hcl_parser"
-	"barbe/core/import_component"
-	"barbe/core/json_parser"
-	"barbe/core/jsonnet_templater"
-	"barbe/core/raw_file"
-	"barbe/core/simplifier_transform"
-	"barbe/core/terraform_fmt"
-	"barbe/core/traversal_manipulator"
-	"barbe/core/wasm"
-	"barbe/core/zipper_fmt"
+	"barbe/core/state_display"
+	"barbe/sdk"
 	"context"
 	"github.com/hashicorp/go-envparse"
+	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"io/fs"
@@ -32,6 +22,10 @@ import (
 )
 
 func makeConfiguredFetcher(ctx context.Context) *fetcher.Fetcher {
+	return makeFetcher(ctx, true)
+}
+
+func makeFetcher(ctx context.Context, useVendor bool) *fetcher.Fetcher {
 	mFetcher := fetcher.NewFetcher()
 	// anyfront/*.*=anyfront/*.*:dev
 	// */aws_iam_role=anyfront/aws_iam_role:dev
@@ -129,6 +123,9 @@ func makeConfiguredFetcher(ctx context.Context) *fetcher.Fetcher {
 			return found[0]
 		})
 	}
+	if useVendor {
+		useVendorIndex(ctx, mFetcher)
+	}
 	return mFetcher
 }
 
@@ -175,65 +172,123 @@ func IterateDirectories(ctx context.Context, command core.MakeCommand, allFiles
 	if err != nil {
 		return errors.Wrap(err, "failed to group files by directory")
 	}
+	if viper.GetString("record") != "" && len(grouped) > 1 {
+		return errors.New("--record only supports input files from a single directory")
+	}
 	for dir, files := range grouped {
-		err := func() error {
-			log.Ctx(ctx).Debug().Msg("executing maker for directory: '" + dir + "'")
-			fileNames := make([]string, 0, len(files))
-			for _, file := range files {
-				fileNames = append(fileNames, file.Name)
-			}
-			log.Ctx(ctx).Debug().Msg("with files: [" + strings.Join(fileNames, ", ") + "]")
+		maker, err := makeMaker(ctx, command, path.Join(viper.GetString("output"), dir))
+		if err != nil {
+			return errors.Wrap(err, "failed to create maker")
+		}
+		err = runInDirectory(ctx, command, dir, files, maker, f)
+		closeMaker(ctx, maker)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			maker, err := makeMaker(ctx, command, path.Join(viper.GetString("output"), dir))
-			if err != nil {
-				return errors.Wrap(err, "failed to create maker")
-			}
+//closeMaker stops the plugins and the templaters of the maker, errors are only logged since the run itself is over
+func closeMaker(ctx context.Context, maker *core.Maker) {
+	err := maker.Close()
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("error closing maker")
+	}
+}
 
-			innerCtx := context.WithValue(ctx, "maker", maker)
+func runInDirectory(ctx context.Context, command core.MakeCommand, dir string, files []fetcher.FileDescription, maker *core.Maker, f func(dirFiles []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error) error {
+	log.Ctx(ctx).Debug().Msg("executing maker for directory: '" + dir + "'")
+	fileNames := make([]string, 0, len(files))
+	for _, file := range files {
+		fileNames = append(fileNames, file.Name)
+	}
+	log.Ctx(ctx).Debug().Msg("with files: [" + strings.Join(fileNames, ", ") + "]")
 
-			err = os.MkdirAll(maker.OutputDir, 0755)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create output dir %s", maker.OutputDir)
-			}
-			readMeFile := path.Join(maker.OutputDir, "README.md")
-			if _, err := os.Stat(readMeFile); os.IsNotExist(err) {
-				err = os.WriteFile(readMeFile, []byte("This directory was generated by barbe. \n\nDo not edit manually. \n\nIt is safe to delete this folder if you have a proper state store configured (ex: `state_store{ s3 {} }`). \n\nThis folder should not be pushed to source control (add it to your .gitignore)"), 0644)
-				if err != nil {
-					return errors.Wrapf(err, "failed to write readme file %s", readMeFile)
-				}
-			}
-			defer chown_util.TryRectifyRootFiles(innerCtx, []string{maker.OutputDir, readMeFile})
+	innerCtx := core.ContextWithMaker(ctx, maker)
 
-			err = f(files, innerCtx, maker)
-			if err != nil {
-				return err
-			}
+	err := os.MkdirAll(maker.OutputDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create output dir %s", maker.OutputDir)
+	}
+	readMeFile := path.Join(maker.OutputDir, "README.md")
+	if _, err := os.Stat(readMeFile); os.IsNotExist(err) {
+		err = os.WriteFile(readMeFile, []byte("This directory was generated by barbe. \n\nDo not edit manually. \n\nIt is safe to delete this folder if you have a proper state store configured (ex: `state_store{ s3 {} }`). \n\nThis folder should not be pushed to source control (add it to your .gitignore)"), 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write readme file %s", readMeFile)
+		}
+	}
+	defer chown_util.TryRectifyRootFiles(innerCtx, []string{maker.OutputDir, readMeFile})
 
-			allPaths := make([]string, 0)
-			err = filepath.WalkDir(maker.OutputDir, func(path string, d fs.DirEntry, err error) error {
-				allPaths = append(allPaths, path)
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-			chown_util.TryRectifyRootFiles(innerCtx, allPaths)
+	lockPath := LockFilePath(files)
+	lock, err := fetcher.ReadLock(lockPath)
+	if err != nil {
+		return err
+	}
+	//the lock is opt-in: it's only created by `barbe lock` or --update-lock
+	if lock == nil && viper.GetBool("update-lock") {
+		lock = fetcher.NewLock()
+	}
+	maker.Fetcher.SetLock(lock, viper.GetBool("update-lock"))
 
-			if command == core.MakeCommandDestroy {
-				err = os.RemoveAll(maker.OutputDir)
-				if err != nil {
-					log.Ctx(ctx).Warn().Err(err).Msg("failed to remove output dir after destroy")
-				}
+	if recordPath := viper.GetString("record"); recordPath != "" {
+		log.Ctx(ctx).Warn().Msg("the recording '" + recordPath + "' will contain the env, the variables (including the sensitive ones) and the state in plaintext, it may hold secrets: don't share it publicly")
+		maker.Recording = core.NewRecording(command, files, maker.Env)
+		maker.Recording.Variables = maker.Variables
+		//the run is recorded even if it fails, that's when it's the most useful
+		defer func() {
+			err := maker.Recording.Write(recordPath, maker.Fetcher)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to write recording")
+				return
 			}
-			return nil
+			log.Ctx(ctx).Info().Msg("recorded the run in '" + recordPath + "'")
 		}()
+	}
+
+	err = f(files, innerCtx, maker)
+	if err != nil {
+		return err
+	}
+
+	//the lock is only written by `barbe lock` or --update-lock, so a new url can't be pinned without anyone noticing
+	if lock != nil && viper.GetBool("update-lock") && maker.Fetcher.LockChanged() {
+		err = lock.Write(lockPath)
 		if err != nil {
 			return err
 		}
+		log.Ctx(ctx).Info().Msg("updated '" + lockPath + "'")
+	}
+
+	allPaths := make([]string, 0)
+	err = filepath.WalkDir(maker.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		allPaths = append(allPaths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	chown_util.TryRectifyRootFiles(innerCtx, allPaths)
+
+	if command == core.MakeCommandDestroy {
+		err = os.RemoveAll(maker.OutputDir)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("failed to remove output dir after destroy")
+		}
 	}
 	return nil
 }
 
+//LockFilePath returns the path of the lock file next to the given input files
+func LockFilePath(files []fetcher.FileDescription) string {
+	for _, file := range files {
+		if _, err := os.Stat(file.Name); err == nil {
+			return path.Join(filepath.Dir(file.Name), fetcher.LockFileName)
+		}
+	}
+	return fetcher.LockFileName
+}
+
 // Glob adds double-star support to the core path/filepath Glob function.
 // inspired by https://github.com/yargevad/filepathx
 func glob(pattern string) ([]string, error) {
@@ -339,36 +394,86 @@ func findCommonPrefix(paths []string) string {
 }
 
 func makeMaker(ctx context.Context, command core.MakeCommand, dir string) (*core.Maker, error) {
-	maker := core.NewMaker(command, makeConfiguredFetcher(ctx))
-	maker.OutputDir = dir
-	maker.Parsers = []core.Parser{
-		hcl_parser.HclParser{},
-		json_parser.JsonParser{},
-	}
-	maker.Templaters = []core.TemplateEngine{
-		//hcl_templater.HclTemplater{},
-		//cue_templater.CueTemplater{},
-		jsonnet_templater.JsonnetTemplater{},
-		wasm.NewWasmTemplater(*zerolog.Ctx(ctx)),
-		wasm.NewSpiderMonkeyTemplater(*zerolog.Ctx(ctx)),
-	}
-	maker.Transformers = []core.Transformer{
-		//the simplifier being first is very important, it simplifies syntax that is equivalent
-		//to make it a lot easier for the transformers to work with
-		simplifier_transform.SimplifierTransformer{},
-		traversal_manipulator.NewTraversalManipulator(),
-		aws_session_provider.AwsSessionProviderTransformer{},
-		gcp_token_provider.GcpTokenProviderTransformer{},
-		raw_file.RawFileFormatter{},
-		buildkit_runner.NewBuildkitRunner(),
-		import_component.NewComponentImporter(),
-	}
-	maker.Formatters = []core.Formatter{
-		terraform_fmt.TerraformFormatter{},
-		zipper_fmt.ZipperFormatter{},
-		raw_file.RawFileFormatter{},
-	}
-	maker.Env = map[string]string{}
+	env, err := readEnv()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := readComponentLimits()
+	if err != nil {
+		return nil, err
+	}
+	variables, err := readVariables()
+	if err != nil {
+		return nil, err
+	}
+	pluginsDir, err := homedir.Expand(viper.GetString("plugins-dir"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error expanding --plugins-dir")
+	}
+	return sdk.NewMaker(ctx, sdk.Options{
+		Command:               command,
+		OutputDir:             dir,
+		Env:                   env,
+		ComponentLimits:       limits,
+		Variables:             variables,
+		DisableComponentCache: viper.GetBool("no-component-cache"),
+		PluginsDir:            pluginsDir,
+		Fetcher:               makeConfiguredFetcher(ctx),
+		StateDisplay:          &state_display.GlobalState,
+	})
+}
+
+func readComponentLimits() (core.ComponentLimits, error) {
+	limits := core.ComponentLimits{}
+	var err error
+	if timeout := viper.GetString("component-timeout"); timeout != "" {
+		limits.Timeout, err = core.ParseComponentTimeout(timeout)
+		if err != nil {
+			return limits, errors.Wrap(err, "error parsing --component-timeout")
+		}
+	}
+	if memoryLimit := viper.GetString("component-memory-limit"); memoryLimit != "" {
+		limits.MemoryLimit, err = core.ParseComponentMemoryLimit(memoryLimit)
+		if err != nil {
+			return limits, errors.Wrap(err, "error parsing --component-memory-limit")
+		}
+	}
+	return limits, nil
+}
+
+func readVariables() (map[string]core.SyntaxToken, error) {
+	variables := map[string]core.SyntaxToken{}
+	for _, varFile := range viper.GetStringSlice("var-file") {
+		content, err := os.ReadFile(varFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldnt read --var-file '"+varFile+"'")
+		}
+		values, err := hcl_parser.ParseVariablesFile(fetcher.FileDescription{
+			Name:    varFile,
+			Content: content,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			variables[name] = value
+		}
+	}
+	for _, varArg := range viper.GetStringSlice("var") {
+		name, value, ok := strings.Cut(varArg, "=")
+		if !ok || name == "" {
+			return nil, errors.New("--var must be written as name=value, got '" + varArg + "'")
+		}
+		variables[name] = core.SyntaxToken{
+			Type:  core.TokenTypeLiteralValue,
+			Value: value,
+		}
+	}
+	return variables, nil
+}
+
+func readEnv() (map[string]string, error) {
+	env := map[string]string{}
 	envArgs := viper.GetStringSlice("env")
 	for _, envArg := range envArgs {
 		if _, err := os.Stat(envArg); !os.IsNotExist(err) {
@@ -383,7 +488,7 @@ func makeMaker(ctx context.Context, command core.MakeCommand, dir string) (*core
 					return errors.Wrap(err, "couldnt parse env file at '"+envArg+"'")
 				}
 				for k, v := range m {
-					maker.Env[k] = v
+					env[k] = v
 				}
 				return nil
 			})()
@@ -393,7 +498,7 @@ func makeMaker(ctx context.Context, command core.MakeCommand, dir string) (*core
 			continue
 		}
 		if envVal, ok := os.LookupEnv(envArg); ok {
-			maker.Env[envArg] = envVal
+			env[envArg] = envVal
 			continue
 		}
 		m, err := envparse.Parse(strings.NewReader(envArg))
@@ -401,19 +506,84 @@ func makeMaker(ctx context.Context, command core.MakeCommand, dir string) (*core
 			return nil, errors.Wrap(err, "couldnt parse --env '"+envArg+"'")
 		}
 		for k, v := range m {
-			maker.Env[k] = v
+			env[k] = v
 		}
 	}
 	//default keys that are included because they are known to not
 	//contain sensitive information and are useful to many use cases
 	defaultEnv := []string{"AWS_REGION", "BARBE_VERBOSE"}
 	for _, k := range defaultEnv {
-		if _, ok := maker.Env[k]; !ok {
+		if _, ok := env[k]; !ok {
 			if envVal, ok := os.LookupEnv(k); ok {
-				maker.Env[k] = envVal
+				env[k] = envVal
 			}
 		}
 	}
+	return env, nil
+}
+
+//RefreshLocks writes the lock file of each directory with the manifests, components and files referenced by the
+//template blocks of the input files, and the components imported while running them in dry run
+func RefreshLocks(ctx context.Context, allFiles []fetcher.FileDescription) ([]string, error) {
+	grouped, err := groupFilesByDirectory(allFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to group files by directory")
+	}
+	lockPaths := make([]string, 0, len(grouped))
+	for dir, files := range grouped {
+		lockPath, err := refreshLock(ctx, dir, files)
+		if err != nil {
+			return nil, err
+		}
+		lockPaths = append(lockPaths, lockPath)
+	}
+	return lockPaths, nil
+}
+
+//refreshLock updates the lock of a single directory. The entries of the existing lock that weren't fetched are kept,
+//the dry run only goes up to the generate step so it can't find the components imported while applying or destroying
+func refreshLock(ctx context.Context, dir string, files []fetcher.FileDescription) (string, error) {
+	maker, err := makeMaker(ctx, core.MakeCommandGenerate, path.Join(viper.GetString("output"), dir))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create maker")
+	}
+	defer closeMaker(ctx, maker)
+	innerCtx := core.ContextWithMaker(ctx, maker)
 
-	return maker, nil
+	lockPath := LockFilePath(files)
+	lock, err := fetcher.ReadLock(lockPath)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		lock = fetcher.NewLock()
+	}
+	maker.Fetcher.SetLock(lock, true)
+
+	//the lock must work for every env and command, not only the current one
+	maker.AllTemplateEntries = true
+	container := core.NewConfigContainer()
+	err = maker.ParseFiles(innerCtx, files, container)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing input files")
+	}
+	_, err = maker.GetTemplates(innerCtx, container)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting templates")
+	}
+
+	//the components fetched through barbe_import_component are only known once the components run,
+	//running them must not write to any persister or run any container
+	maker.AllTemplateEntries = false
+	maker.DryRun = true
+	_, err = maker.Validate(innerCtx, files)
+	if err != nil {
+		return "", errors.Wrap(err, "error running the components in dry run")
+	}
+
+	err = lock.Write(lockPath)
+	if err != nil {
+		return "", err
+	}
+	return lockPath, nil
 }
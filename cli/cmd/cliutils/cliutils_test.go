@@ -0,0 +1,80 @@
+package cliutils
+
+import (
+	"barbe/core/fetcher"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRefreshLocksImportedComponents(t *testing.T) {
+	var serverUrl string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.hcl":
+			w.Write([]byte(`components = ["` + serverUrl + `/parent.jsonnet"]`))
+		case "/parent.jsonnet":
+			w.Write([]byte(`{
+				Databags: [{
+					Type: "barbe_import_component",
+					Name: "child",
+					Value: { url: "` + serverUrl + `/child.jsonnet", input: {} },
+				}],
+			}`))
+		case "/child.jsonnet":
+			w.Write([]byte(`{ Databags: [] }`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverUrl = server.URL
+
+	dir := t.TempDir()
+	viper.Set("output", filepath.Join(dir, "dist"))
+	viper.Set("no-component-cache", true)
+	defer viper.Reset()
+
+	configPath := filepath.Join(dir, "config.hcl")
+	config := []byte(`template {
+		manifest = "` + serverUrl + `/manifest.hcl"
+	}`)
+	err := os.WriteFile(configPath, config, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//an entry added by running with --update-lock, that the dry run doesn't reach
+	previous := fetcher.NewLock()
+	previous.Entries["https://example.com/apply_only.jsonnet"] = fetcher.LockEntry{ResolvedUrl: "https://example.com/apply_only.jsonnet", Sha256: "0"}
+	err = previous.Write(filepath.Join(dir, fetcher.LockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockPaths, err := RefreshLocks(context.Background(), []fetcher.FileDescription{{Name: configPath, Content: config}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockPaths) != 1 {
+		t.Fatalf("expected a single lock file, got %v", lockPaths)
+	}
+	lock, err := fetcher.ReadLock(lockPaths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{
+		serverUrl + "/manifest.hcl",
+		serverUrl + "/parent.jsonnet",
+		serverUrl + "/child.jsonnet",
+		"https://example.com/apply_only.jsonnet",
+	} {
+		if _, ok := lock.Entries[url]; !ok {
+			t.Errorf("expected '%s' in the lock, got %v", url, lock.Entries)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"barbe/core/hcl_parser"
+	"barbe/sdk"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ComponentTestDatabagType = "barbe_test"
+
+/*
+ComponentTestCase is read from a block like:
+
+	barbe_test "creates_bucket" {
+		component = "./bucket.js"
+		input = "./testdata/bucket.input.hcl"
+		expected = "./testdata/bucket.expected.json"
+		step = "generate"
+		command = "generate"
+		env = { STAGE = "dev" }
+	}
+
+paths are relative to the file the block is in
+*/
+type ComponentTestCase struct {
+	File      string
+	Name      string
+	Component string
+	Input     string
+	Expected  string
+	Step      core.MakeLifecycleStep
+	Command   core.MakeCommand
+	Env       map[string]string
+}
+
+type ComponentTestResult struct {
+	Case ComponentTestCase
+	//Diff is empty if the test passed
+	Diff    []string
+	Err     error
+	Updated bool
+}
+
+func (r ComponentTestResult) Passed() bool {
+	return r.Err == nil && len(r.Diff) == 0
+}
+
+//RunComponentTests runs every barbe_test block found in testFiles, each component is applied once to its input
+//in dry run mode and without state stores, so no container is executed and no state is persisted.
+//If update is true the expected files are overwritten with the actual output instead of being compared
+func RunComponentTests(ctx context.Context, testFiles []fetcher.FileDescription, update bool) ([]ComponentTestResult, error) {
+	cases := make([]ComponentTestCase, 0)
+	for _, file := range testFiles {
+		fileCases, err := parseComponentTestCases(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, fileCases...)
+	}
+
+	outputDir, err := os.MkdirTemp("", "barbe_test")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary output directory")
+	}
+	defer os.RemoveAll(outputDir)
+
+	//the maker is reused between cases so the templaters are only initialized once
+	maker, err := makeMaker(ctx, core.MakeCommandGenerate, outputDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create maker")
+	}
+	defer closeMaker(ctx, maker)
+	results := make([]ComponentTestResult, 0, len(cases))
+	for _, testCase := range cases {
+		maker.Reset()
+		maker.Transformers = append(sdk.DefaultTransformers(), maker.PluginTransformers()...)
+		maker.DryRun = true
+		maker.StateHandler.IgnoreStateStores = true
+		maker.Command = testCase.Command
+		maker.CurrentStep = testCase.Step
+		maker.Env = testCase.Env
+
+		result := ComponentTestResult{
+			Case: testCase,
+		}
+		result.Diff, result.Updated, result.Err = runComponentTest(core.ContextWithMaker(ctx, maker), maker, testCase, update)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runComponentTest(ctx context.Context, maker *core.Maker, testCase ComponentTestCase, update bool) (diff []string, updated bool, e error) {
+	component, err := maker.Fetcher.Fetch(testCase.Component)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to fetch component")
+	}
+	maker.Executable = core.Executable{
+		Components: []fetcher.FileDescription{component},
+	}
+	inputFile, err := maker.Fetcher.Fetch(testCase.Input)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to fetch input")
+	}
+	input := core.NewConfigContainer()
+	err = maker.ParseFiles(ctx, []fetcher.FileDescription{inputFile}, input)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to parse input")
+	}
+	err = maker.TransformInPlace(ctx, input)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to transform input")
+	}
+
+	output, err := maker.ApplyComponent(ctx, component, *input)
+	if err != nil {
+		return nil, false, err
+	}
+	//round trip through json so the literal values have the same types as the ones read from the expected file
+	actualJson, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to marshal output")
+	}
+	var actual core.ConfigContainer
+	err = json.Unmarshal(actualJson, &actual)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to unmarshal output")
+	}
+
+	if update {
+		err = os.MkdirAll(filepath.Dir(testCase.Expected), 0755)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to create directory for expected file")
+		}
+		err = os.WriteFile(testCase.Expected, actualJson, 0644)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to write expected file")
+		}
+		return nil, true, nil
+	}
+
+	expectedJson, err := os.ReadFile(testCase.Expected)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to read expected file, use --update to create it")
+	}
+	var expected core.ConfigContainer
+	err = json.Unmarshal(expectedJson, &expected)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to parse expected file '"+testCase.Expected+"'")
+	}
+	return core.DiffContainers(expected, actual), false, nil
+}
+
+func parseComponentTestCases(ctx context.Context, file fetcher.FileDescription) ([]ComponentTestCase, error) {
+	container := core.NewConfigContainer()
+	err := hcl_parser.HclParser{}.Parse(ctx, file, container)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(file.Name)
+	output := make([]ComponentTestCase, 0)
+	for _, bag := range container.GetDataBagsOfType(ComponentTestDatabagType) {
+		testCase := ComponentTestCase{
+			File:    file.Name,
+			Name:    bag.Name,
+			Step:    core.MakeLifecycleStepGenerate,
+			Command: core.MakeCommandGenerate,
+			Env:     map[string]string{},
+		}
+		if bag.Value.Type != core.TokenTypeObjectConst {
+			return nil, fmt.Errorf("%s: '%s.%s' must be a block", file.Name, ComponentTestDatabagType, bag.Name)
+		}
+		for _, pair := range bag.Value.ObjectConst {
+			if pair.Key == "env" {
+				if pair.Value.Type != core.TokenTypeObjectConst {
+					return nil, fmt.Errorf("%s: '%s.%s.env' must be an object", file.Name, ComponentTestDatabagType, bag.Name)
+				}
+				for _, envPair := range pair.Value.ObjectConst {
+					str, err := core.ExtractAsStringValue(envPair.Value)
+					if err != nil {
+						return nil, errors.Wrapf(err, "%s: '%s.%s.env.%s'", file.Name, ComponentTestDatabagType, bag.Name, envPair.Key)
+					}
+					testCase.Env[envPair.Key] = str
+				}
+				continue
+			}
+			str, err := core.ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: '%s.%s.%s'", file.Name, ComponentTestDatabagType, bag.Name, pair.Key)
+			}
+			switch pair.Key {
+			case "component":
+				testCase.Component = relativeToTestFile(dir, str)
+			case "input":
+				testCase.Input = relativeToTestFile(dir, str)
+			case "expected":
+				//the expected file might not exist yet if running with --update
+				testCase.Expected = str
+				if !filepath.IsAbs(str) {
+					testCase.Expected = path.Join(dir, str)
+				}
+			case "step":
+				testCase.Step = str
+			case "command":
+				testCase.Command = str
+			default:
+				return nil, fmt.Errorf("%s: unknown attribute '%s' in '%s.%s'", file.Name, pair.Key, ComponentTestDatabagType, bag.Name)
+			}
+		}
+		if testCase.Component == "" || testCase.Input == "" || testCase.Expected == "" {
+			return nil, fmt.Errorf("%s: '%s.%s' requires 'component', 'input' and 'expected'", file.Name, ComponentTestDatabagType, bag.Name)
+		}
+		output = append(output, testCase)
+	}
+	return output, nil
+}
+
+//relativeToTestFile resolves local paths relative to the test file, urls and hub identifiers are left as is
+func relativeToTestFile(dir string, p string) string {
+	if filepath.IsAbs(p) || strings.Contains(p, "://") {
+		return p
+	}
+	joined := path.Join(dir, p)
+	if _, _, _, _, err := fetcher.ParseBarbeHubIdentifier(p); err == nil {
+		//hub identifiers can look like relative paths, prefer the local file if there is one
+		if _, err := os.Stat(joined); err != nil {
+			return p
+		}
+	}
+	return joined
+}
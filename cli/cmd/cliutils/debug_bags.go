@@ -0,0 +1,52 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path"
+)
+
+//debugBag adds the provenance to the serialized databag, it is otherwise omitted
+type debugBag struct {
+	core.DataBag
+	Provenance []core.Provenance `json:",omitempty"`
+}
+
+//WriteDebugBags writes the container to debug-bags.json in the output directory (for --debug-bags), errors are only logged
+func WriteDebugBags(ctx context.Context, outputDir string, container *core.ConfigContainer) {
+	if container == nil {
+		return
+	}
+	container, err := container.Redacted()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to redact container (for --debug-bags)")
+		return
+	}
+	bags := map[string]map[string][]debugBag{}
+	for typeName, databags := range container.DataBags {
+		bags[typeName] = map[string][]debugBag{}
+		for name, group := range databags {
+			for _, bag := range group {
+				bags[typeName][name] = append(bags[typeName][name], debugBag{
+					DataBag:    bag,
+					Provenance: bag.Provenance,
+				})
+			}
+		}
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"DataBags": bags}, "", "  ")
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to marshal container (for --debug-bags)")
+		return
+	}
+	outputFile := path.Join(outputDir, "debug-bags.json")
+	err = os.WriteFile(outputFile, b, 0644)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to write debug-bags.json")
+		return
+	}
+	log.Ctx(ctx).Info().Msg("wrote databags at '" + outputFile + "'")
+}
@@ -0,0 +1,117 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"barbe/core/state_display"
+	"barbe/sdk"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Replay runs the generate steps of a recording made with --record. Only the recorded files are fetched, the state
+//comes from the recording and no container is executed, the recorded read back files are used instead
+func Replay(ctx context.Context, recording *core.Recording) (*core.ConfigContainer, *core.Maker, error) {
+	outputDir, err := filepath.Abs(viper.GetString("output"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error resolving output dir")
+	}
+	dir, err := os.MkdirTemp("", "barbe_replay")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating replay directory")
+	}
+	defer os.RemoveAll(dir)
+	baseDir, err := restoreLocalFiles(ctx, dir, recording)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mFetcher := fetcher.NewFetcher()
+	mFetcher.BaseDir = baseDir
+	mFetcher.UseFetchedFiles(recording.FetchedFiles)
+	limits, err := readComponentLimits()
+	if err != nil {
+		return nil, nil, err
+	}
+	maker, err := sdk.NewMaker(ctx, sdk.Options{
+		Command:         core.MakeCommandGenerate,
+		BaseDir:         baseDir,
+		OutputDir:       outputDir,
+		Env:             recording.Env,
+		Variables:       recording.Variables,
+		ComponentLimits: limits,
+		//a cached output could hide the bug being reproduced
+		DisableComponentCache: true,
+		Fetcher:               mFetcher,
+		StateDisplay:          &state_display.GlobalState,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create maker")
+	}
+	maker.Replaying = recording
+
+	err = os.MkdirAll(maker.OutputDir, 0755)
+	if err != nil {
+		return nil, maker, errors.Wrapf(err, "failed to create output dir %s", maker.OutputDir)
+	}
+	container, err := maker.Make(core.ContextWithMaker(ctx, maker), recording.InputFiles)
+	return container, maker, err
+}
+
+//restoreLocalFiles writes the recorded local files in dir, because the type of a local file is only found from its
+//extension if it exists on disk. It returns the directory the recorded relative paths are restored from
+func restoreLocalFiles(ctx context.Context, dir string, recording *core.Recording) (string, error) {
+	files := append([]fetcher.FileDescription{}, recording.InputFiles...)
+	for _, file := range recording.FetchedFiles {
+		files = append(files, file)
+	}
+	localPaths := map[string][]byte{}
+	//files recorded as "../x.hcl" are restored in a subdirectory deep enough to keep them in dir
+	depth := 0
+	for _, file := range files {
+		if strings.Contains(file.Name, "://") {
+			continue
+		}
+		if _, _, _, _, err := fetcher.ParseBarbeHubIdentifier(file.Name); err == nil {
+			continue
+		}
+		if filepath.IsAbs(file.Name) {
+			log.Ctx(ctx).Warn().Msgf("'%s' can't be restored because its path is absolute, it may be ignored", file.Name)
+			continue
+		}
+		p := filepath.Clean(file.Name)
+		localPaths[p] = file.Content
+		d := 0
+		for strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+			p = strings.TrimPrefix(p, ".."+string(filepath.Separator))
+			d++
+		}
+		if d > depth {
+			depth = d
+		}
+	}
+	for i := 0; i < depth; i++ {
+		dir = filepath.Join(dir, "_")
+	}
+	for p, content := range localPaths {
+		fullPath := filepath.Join(dir, p)
+		err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+		if err != nil {
+			return "", errors.Wrap(err, "error restoring '"+p+"'")
+		}
+		err = os.WriteFile(fullPath, content, 0644)
+		if err != nil {
+			return "", errors.Wrap(err, "error restoring '"+p+"'")
+		}
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating replay directory")
+	}
+	return dir, nil
+}
@@ -0,0 +1,93 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"path"
+	"sort"
+	"strings"
+)
+
+//LoadStateMaker creates a maker with the persisters declared in the files matching globExprs and reads the state from them.
+//The state stores are found by running the components up to the generate step in dry run, so they are configured
+//exactly like in a real run (ex: the state_store component applying its defaults to `state_store { s3 {} }`).
+//The maker is returned in dry run mode, set DryRun to false before persisting changes, and must be closed by the caller
+func LoadStateMaker(ctx context.Context, globExprs []string) (*core.Maker, error) {
+	allFiles, err := ReadAllFilesMatching(ctx, globExprs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read files")
+	}
+	if len(allFiles) == 0 {
+		return nil, errors.New("no file matching " + strings.Join(globExprs, ", "))
+	}
+	grouped, err := groupFilesByDirectory(allFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to group files by directory")
+	}
+	if len(grouped) != 1 {
+		dirs := make([]string, 0, len(grouped))
+		for dir := range grouped {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		return nil, errors.New("the state commands operate on a single directory, found files in: " + strings.Join(dirs, ", "))
+	}
+
+	dir := ""
+	var files []fetcher.FileDescription
+	for d, f := range grouped {
+		dir, files = d, f
+	}
+
+	maker, err := makeMaker(ctx, core.MakeCommandApply, path.Join(viper.GetString("output"), dir))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create maker")
+	}
+	stores, err := findStateStores(ctx, maker, files)
+	if err != nil {
+		maker.Close()
+		return nil, err
+	}
+	if len(stores) == 0 {
+		maker.Close()
+		return nil, errors.New("no state_store found in the input files")
+	}
+
+	//the components may have changed the state in memory while running,
+	//the state commands work on what the stores contain
+	maker.Reset()
+	storeContainer := core.NewConfigContainer()
+	for _, bag := range stores {
+		err = storeContainer.Insert(bag)
+		if err != nil {
+			maker.Close()
+			return nil, err
+		}
+	}
+	err = maker.StateHandler.CreatePersisters(ctx, storeContainer)
+	if err != nil {
+		maker.Close()
+		return nil, errors.Wrap(err, "error creating persisters")
+	}
+	return maker, nil
+}
+
+//findStateStores runs the pre_generate and generate steps in dry run and returns the barbe_state_store databags they produced
+func findStateStores(ctx context.Context, maker *core.Maker, files []fetcher.FileDescription) ([]core.DataBag, error) {
+	//reading the state must not write to any persister or run any container
+	maker.DryRun = true
+	lock, err := fetcher.ReadLock(LockFilePath(files))
+	if err != nil {
+		return nil, err
+	}
+	maker.Fetcher.SetLock(lock, false)
+
+	container, err := maker.Validate(core.ContextWithMaker(ctx, maker), files)
+	if err != nil {
+		return nil, errors.Wrap(err, "error running the components declaring the state stores")
+	}
+	return container.GetDataBagsOfType(core.StateStoreDatabagType), nil
+}
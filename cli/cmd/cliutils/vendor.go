@@ -0,0 +1,145 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//useVendorIndex makes mFetcher serve the files vendored by `barbe vendor`, if the vendor directory has an index
+func useVendorIndex(ctx context.Context, mFetcher *fetcher.Fetcher) {
+	vendorDir := viper.GetString("vendor-dir")
+	if vendorDir == "" {
+		return
+	}
+	index, err := fetcher.ReadVendorIndex(vendorDir)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("ignoring vendor directory")
+		return
+	}
+	if index == nil {
+		return
+	}
+	log.Ctx(ctx).Debug().Msgf("using %d vendored files from '%s'", len(index.Files), vendorDir)
+	mFetcher.UseVendorIndex(vendorDir, index)
+}
+
+//VendorFiles downloads the manifests, components and files referenced by the template blocks of the input files
+//into the vendor directory, and rewrites its index. It returns the urls that were vendored
+func VendorFiles(ctx context.Context, allFiles []fetcher.FileDescription) ([]string, error) {
+	vendorDir := viper.GetString("vendor-dir")
+	if vendorDir == "" {
+		return nil, errors.New("--vendor-dir cannot be empty")
+	}
+	grouped, err := groupFilesByDirectory(allFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to group files by directory")
+	}
+
+	index := fetcher.NewVendorIndex()
+	contents := map[string][]byte{}
+	for dir, files := range grouped {
+		maker, err := makeMaker(ctx, core.MakeCommandGenerate, path.Join(viper.GetString("output"), dir))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create maker")
+		}
+		defer closeMaker(ctx, maker)
+		//the files must come from their source, not from a previous vendoring
+		maker.Fetcher = makeFetcher(ctx, false)
+		//the vendor directory must work for every env and command, not only the current one
+		maker.AllTemplateEntries = true
+		innerCtx := core.ContextWithMaker(ctx, maker)
+
+		lockPath := LockFilePath(files)
+		lock, err := fetcher.ReadLock(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		maker.Fetcher.SetLock(lock, viper.GetBool("update-lock"))
+
+		container := core.NewConfigContainer()
+		err = maker.ParseFiles(innerCtx, files, container)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing input files")
+		}
+		_, err = maker.GetTemplates(innerCtx, container)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting templates")
+		}
+
+		if lock != nil && viper.GetBool("update-lock") && maker.Fetcher.LockChanged() {
+			err = lock.Write(lockPath)
+			if err != nil {
+				return nil, err
+			}
+			log.Ctx(ctx).Info().Msg("updated '" + lockPath + "'")
+		}
+
+		for requestedUrl, resolvedUrl := range maker.Fetcher.ResolvedUrls() {
+			if strings.HasPrefix(resolvedUrl, "base64://") {
+				continue
+			}
+			if fetcher.IsLocalUrl(resolvedUrl) {
+				log.Ctx(ctx).Warn().Msgf("'%s' resolved to the local file '%s', it was not vendored", requestedUrl, resolvedUrl)
+				continue
+			}
+			file, err := maker.Fetcher.Fetch(requestedUrl)
+			if err != nil {
+				return nil, errors.Wrap(err, "error fetching '"+requestedUrl+"'")
+			}
+			vendoredPath := fetcher.VendorPath(resolvedUrl)
+			index.Files[requestedUrl] = fetcher.VendorEntry{
+				Path:        vendoredPath,
+				ResolvedUrl: resolvedUrl,
+			}
+			contents[vendoredPath] = file.Content
+		}
+	}
+
+	//the previous vendoring is only removed once everything was fetched successfully,
+	//and only if it has an index, to never delete a directory that wasn't created by barbe
+	previousIndex, err := fetcher.ReadVendorIndex(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	if previousIndex != nil {
+		err = os.RemoveAll(vendorDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to remove previous vendor directory '"+vendorDir+"'")
+		}
+	}
+	for vendoredPath, content := range contents {
+		filePath := filepath.Join(vendorDir, filepath.FromSlash(vendoredPath))
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create directory for '"+filePath+"'")
+		}
+		err = os.WriteFile(filePath, content, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to write '"+filePath+"'")
+		}
+	}
+	err = os.MkdirAll(vendorDir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create vendor directory '"+vendorDir+"'")
+	}
+	err = index.Write(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+
+	vendored := make([]string, 0, len(index.Files))
+	for requestedUrl := range index.Files {
+		vendored = append(vendored, requestedUrl)
+	}
+	sort.Strings(vendored)
+	return vendored, nil
+}
@@ -0,0 +1,154 @@
+package cliutils
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"barbe/core/state_display"
+	"barbe/sdk"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+//WatchDirectories runs f for each directory like IterateDirectories, then again every time one of the input files,
+//--env files, --var-file files or local components changes. It returns when ctx is done.
+//One maker is kept per directory, so the fetcher cache and the templaters (and their warmed up runtimes) are reused between runs
+func WatchDirectories(ctx context.Context, command core.MakeCommand, globExprs []string, interval time.Duration, f func(dirFiles []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error) error {
+	makers := map[string]*core.Maker{}
+	defer func() {
+		for _, maker := range makers {
+			closeMaker(ctx, maker)
+		}
+	}()
+	runAll := func() error {
+		allFiles, err := ReadAllFilesMatching(ctx, globExprs)
+		if err != nil {
+			return errors.Wrap(err, "failed to read files")
+		}
+		grouped, err := groupFilesByDirectory(allFiles)
+		if err != nil {
+			return errors.Wrap(err, "failed to group files by directory")
+		}
+		env, err := readEnv()
+		if err != nil {
+			return err
+		}
+		variables, err := readVariables()
+		if err != nil {
+			return err
+		}
+
+		state_display.GlobalState.Reset()
+		for dir, files := range grouped {
+			maker, ok := makers[dir]
+			if !ok {
+				maker, err = makeMaker(ctx, command, path.Join(viper.GetString("output"), dir))
+				if err != nil {
+					return errors.Wrap(err, "failed to create maker")
+				}
+				makers[dir] = maker
+			} else {
+				maker.Reset()
+				maker.Env = env
+				maker.Variables = variables
+				maker.Transformers = append(sdk.DefaultTransformers(), maker.PluginTransformers()...)
+				maker.Fetcher.InvalidateLocalFiles()
+			}
+			err = runInDirectory(ctx, command, dir, files, maker, f)
+			if err != nil {
+				//keep watching, the next change is likely the fix
+				log.Ctx(ctx).Error().Err(err).Msg("failed for directory '" + dir + "'")
+			}
+		}
+		return nil
+	}
+	watchedPaths := func() []string {
+		paths := make([]string, 0)
+		for _, globExpr := range globExprs {
+			matches, err := glob(globExpr)
+			if err != nil {
+				continue
+			}
+			paths = append(paths, matches...)
+		}
+		for _, envArg := range viper.GetStringSlice("env") {
+			if info, err := os.Stat(envArg); err == nil && !info.IsDir() {
+				paths = append(paths, envArg)
+			}
+		}
+		for _, varFile := range viper.GetStringSlice("var-file") {
+			if info, err := os.Stat(varFile); err == nil && !info.IsDir() {
+				paths = append(paths, varFile)
+			}
+		}
+		for _, maker := range makers {
+			paths = append(paths, maker.Fetcher.LocalFiles()...)
+		}
+		return paths
+	}
+
+	err := runAll()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("")
+	}
+	snapshot := snapshotFiles(watchedPaths())
+	log.Ctx(ctx).Info().Msgf("watching %d file(s) for changes", len(snapshot))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		changed := changedFiles(snapshot, snapshotFiles(watchedPaths()))
+		if len(changed) == 0 {
+			continue
+		}
+		log.Ctx(ctx).Info().Msgf("change detected in %v, running again", changed)
+		t := time.Now()
+		err = runAll()
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("")
+		}
+		log.Ctx(ctx).Info().Msgf("done in %s", time.Since(t))
+		snapshot = snapshotFiles(watchedPaths())
+	}
+}
+
+//snapshotFiles returns a fingerprint of each file that changes when the file is modified or removed
+func snapshotFiles(paths []string) map[string]string {
+	output := make(map[string]string, len(paths))
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			output[p] = "missing"
+			continue
+		}
+		output[p] = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	}
+	return output
+}
+
+func changedFiles(before map[string]string, after map[string]string) []string {
+	changed := make([]string, 0)
+	for p, fingerprint := range after {
+		if before[p] != fingerprint {
+			changed = append(changed, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -7,13 +7,10 @@ import (
 	"barbe/core"
 	"barbe/core/fetcher"
 	"context"
-	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
-	"path"
 )
 
 var destroyCmd = &cobra.Command{
@@ -61,17 +58,7 @@ var destroyCmd = &cobra.Command{
 				return errors.Wrap(err, "generation failed")
 			}
 			if viper.GetBool("debug-bags") {
-				b, err := json.MarshalIndent(container, "", "  ")
-				if err != nil {
-					log.Ctx(ctx).Error().Err(err).Msg("failed to marshal container (for --debug-bags)")
-				} else {
-					outputFile := path.Join(maker.OutputDir, "debug-bags.json")
-					err = os.WriteFile(outputFile, b, 0644)
-					if err != nil {
-						log.Ctx(ctx).Error().Err(err).Msg("failed to write debug-bags.json")
-					}
-					log.Ctx(ctx).Info().Msg("wrote databags at '" + outputFile + "'")
-				}
+				cliutils.WriteDebugBags(ctx, maker.OutputDir, container)
 			}
 			return nil
 		})
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain TYPE NAME [GLOB...]",
+	Short: "Run the generate step and show where a databag came from: the files, components and transformers that contributed to it",
+	Long: "Run the generate step and show where a databag came from: the files, components and transformers that contributed to it.\n" +
+		"Each contributor is listed with the top level attributes it set",
+	Args:         cobra.MinimumNArgs(2),
+	Example:      "barbe explain cr_aws_lambda_function my_function config.hcl\nbarbe explain cr_aws_lambda_function my_function --attribute role",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		bagType, bagName, globs := args[0], args[1], args[2:]
+		if len(globs) == 0 {
+			globs = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", globs)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, globs)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		attribute := viper.GetString("attribute")
+		found := false
+		err = cliutils.IterateDirectories(ctx, core.MakeCommandGenerate, allFiles, func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			maker.DryRun = true
+			maker.SkipFormatters = true
+			container, err := maker.Make(ctx, files)
+			if err != nil {
+				return errors.Wrap(err, "generation failed")
+			}
+			for _, bag := range container.GetDataBagGroup(bagType, bagName) {
+				found = true
+				printProvenance(bag, attribute)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("no databag '" + bagType + "." + bagName + "' after generation")
+		}
+		return nil
+	},
+}
+
+func printProvenance(bag core.DataBag, attribute string) {
+	id := bag.Type + "." + bag.Name
+	if len(bag.Labels) != 0 {
+		id += "[" + strings.Join(bag.Labels, ", ") + "]"
+	}
+	fmt.Println(id)
+	for _, provenance := range bag.Provenance {
+		if attribute != "" {
+			if _, ok := provenance.Attributes[attribute]; !ok {
+				continue
+			}
+		}
+		attributes := make([]string, 0, len(provenance.Attributes))
+		for _, name := range provenance.AttributeNames() {
+			if attribute != "" && name != attribute {
+				continue
+			}
+			if r := provenance.Attributes[name]; r != nil {
+				name += " (" + r.String() + ")"
+			}
+			attributes = append(attributes, name)
+		}
+		line := "  - " + provenance.String()
+		if len(attributes) != 0 {
+			line += ", set: " + strings.Join(attributes, ", ")
+		}
+		fmt.Println(line)
+	}
+}
+
+func init() {
+	explainCmd.Flags().String("attribute", "", "Only show the contributors that set this top level attribute")
+}
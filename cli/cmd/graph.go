@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [GLOB...]",
+	Short: "Run the generate step and output the graph of manifests, components and the databag types they produced",
+	Long: "Run the generate step and output the graph of manifests, components and the databag types they produced.\n" +
+		"If the generation fails, the graph of what ran until the failure is still written",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe graph config.hcl | dot -Tsvg > graph.svg\nbarbe graph config.hcl --format json",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		format := viper.GetString("format")
+		if format != "dot" && format != "json" {
+			return errors.New("unsupported graph format '" + format + "', expected 'dot' or 'json'")
+		}
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		graph := core.NewDependencyGraph()
+		makeErr := cliutils.IterateDirectories(ctx, core.MakeCommandGenerate, allFiles, func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			maker.DryRun = true
+			maker.SkipFormatters = true
+			defer graph.MergeWith(maker.Graph)
+			_, err := maker.Make(ctx, files)
+			return err
+		})
+
+		switch format {
+		case "dot":
+			err = graph.WriteDot(os.Stdout)
+		case "json":
+			var b []byte
+			b, err = json.MarshalIndent(graph, "", "  ")
+			if err == nil {
+				fmt.Println(string(b))
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to write graph")
+		}
+		if makeErr != nil {
+			return errors.Wrap(makeErr, "generation failed, the graph is partial")
+		}
+		return nil
+	},
+}
+
+func init() {
+	graphCmd.Flags().String("format", "dot", "Output format of the graph (dot, json)")
+}
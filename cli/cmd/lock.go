@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lockCmd = &cobra.Command{
+	Use:          "lock [GLOB...]",
+	Short:        "Create or refresh the barbe.lock file pinning the content of the manifests, components and files used by the configuration",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe lock config.hcl\nbarbe lock **/*.hcl",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		lockPaths, err := cliutils.RefreshLocks(ctx, allFiles)
+		if err != nil {
+			return err
+		}
+		for _, lockPath := range lockPaths {
+			log.Ctx(ctx).Info().Msg("wrote '" + lockPath + "'")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"barbe/analytics"
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/buildkit_runner"
+	"barbe/core/fetcher"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"sort"
+	"strings"
+)
+
+type directoryPlan struct {
+	OutputDir string
+	Runs      []buildkit_runner.PlannedRun
+}
+
+var planCmd = &cobra.Command{
+	Use:          "plan [GLOB...]",
+	Short:        "Run the apply lifecycle without executing any container or persisting any state, and report what apply would do",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe plan config.hcl --output dist\nbarbe plan **/*.hcl --plan-output plan.json",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		fileNames := make([]string, 0, len(allFiles))
+		for _, file := range allFiles {
+			fileNames = append(fileNames, file.Name)
+		}
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionStart",
+			EventProperties: map[string]interface{}{
+				"Files":       fileNames,
+				"FileCount":   len(allFiles),
+				"CurrentStep": "plan",
+			},
+		})
+
+		plans := make([]directoryPlan, 0)
+		err = cliutils.IterateDirectories(ctx, core.MakeCommandApply, allFiles, func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			maker.DryRun = true
+			container, err := maker.Make(ctx, files)
+			if err != nil {
+				return errors.Wrap(err, "planning failed")
+			}
+			if viper.GetBool("debug-bags") {
+				cliutils.WriteDebugBags(ctx, maker.OutputDir, container)
+			}
+
+			plan := directoryPlan{
+				OutputDir: maker.OutputDir,
+				Runs:      []buildkit_runner.PlannedRun{},
+			}
+			for _, transformer := range maker.Transformers {
+				if runner, ok := transformer.(*buildkit_runner.BuildkitRunner); ok {
+					plan.Runs = append(plan.Runs, runner.PlannedRuns()...)
+				}
+			}
+			plans = append(plans, plan)
+			logPlan(ctx, plan)
+			return nil
+		})
+		if err != nil {
+			analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+				EventType: "ExecutionEnd",
+				EventProperties: map[string]interface{}{
+					"Error": err.Error(),
+				},
+			})
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+
+		if planOutput := viper.GetString("plan-output"); planOutput != "" {
+			b, err := json.MarshalIndent(plans, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal plan")
+			}
+			err = os.WriteFile(planOutput, b, 0644)
+			if err != nil {
+				return errors.Wrap(err, "failed to write plan at '"+planOutput+"'")
+			}
+			log.Ctx(ctx).Info().Msg("wrote plan at '" + planOutput + "'")
+		}
+
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionEnd",
+			EventProperties: map[string]interface{}{
+				"Success": true,
+			},
+		})
+		return nil
+	},
+}
+
+func init() {
+	planCmd.Flags().String("plan-output", "", "Also write the plan as JSON to the given file")
+}
+
+func logPlan(ctx context.Context, plan directoryPlan) {
+	if len(plan.Runs) == 0 {
+		log.Ctx(ctx).Info().Msgf("'%s': no container would be executed", plan.OutputDir)
+		return
+	}
+	log.Ctx(ctx).Info().Msgf("'%s': %d container(s) would be executed", plan.OutputDir, len(plan.Runs))
+	for _, run := range plan.Runs {
+		lines := []string{
+			fmt.Sprintf("[%s] %s", run.Step, run.DisplayName),
+			"  dockerfile sha256: " + run.DockerfileHash,
+		}
+		if len(run.InputFiles) != 0 {
+			lines = append(lines, "  input files:")
+			for _, name := range sortedKeys(run.InputFiles) {
+				lines = append(lines, fmt.Sprintf("    %s (sha256: %s)", name, run.InputFiles[name]))
+			}
+		}
+		if len(run.ExportedFiles) != 0 {
+			lines = append(lines, "  exported files:")
+			for _, containerPath := range sortedKeys(run.ExportedFiles) {
+				lines = append(lines, fmt.Sprintf("    %s -> %s", containerPath, run.ExportedFiles[containerPath]))
+			}
+		}
+		log.Ctx(ctx).Info().Msg(strings.Join(lines, "\n"))
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/version"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay FILE",
+	Short: "Run the generate steps again from a recording made with --record, without network access",
+	Long: "Run the generate steps again from a recording made with --record.\n" +
+		"The input files, fetched files, env, state and files read back from containers all come from the recording, " +
+		"nothing is fetched and no container is executed",
+	Args:         cobra.ExactArgs(1),
+	Example:      "barbe generate config.hcl --record bug.barbe.gz\nbarbe replay bug.barbe.gz --debug-bags",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		recording, err := core.ReadRecording(args[0])
+		if err != nil {
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+		if recording.BarbeVersion != version.Version {
+			log.Ctx(ctx).Warn().Msgf("the recording was made with barbe %s, the output may differ with this version (%s)", recording.BarbeVersion, version.Version)
+		}
+		log.Ctx(ctx).Debug().Msgf("replaying '%s' run with %d input files and %d fetched files", recording.Command, len(recording.InputFiles), len(recording.FetchedFiles))
+
+		container, maker, err := cliutils.Replay(ctx, recording)
+		if maker != nil {
+			defer maker.Close()
+		}
+		if container != nil && viper.GetBool("debug-bags") {
+			cliutils.WriteDebugBags(core.ContextWithMaker(ctx, maker), maker.OutputDir, container)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "replay failed")
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+		return nil
+	},
+}
@@ -22,6 +22,15 @@ func init() {
 	rootCmd.PersistentFlags().Bool("auto-approve", false, "Automatically approve all yes/no prompts")
 	rootCmd.PersistentFlags().StringP("output", "o", "barbe_dist", "Output directory")
 	rootCmd.PersistentFlags().Bool("debug-bags", false, "Outputs the resulting databags to the output directory, for debugging purposes")
+	rootCmd.PersistentFlags().Bool("update-lock", false, "Accept the manifests, components and files whose content doesn't match barbe.lock, and update the lock")
+	rootCmd.PersistentFlags().String("vendor-dir", "barbe_vendor", "Directory written by `barbe vendor`, the files it contains are used instead of fetching them")
+	rootCmd.PersistentFlags().Bool("no-component-cache", false, "Always execute the components instead of reusing their output from a previous run with the same input")
+	rootCmd.PersistentFlags().String("component-timeout", "", "Maximum execution time of each component (ex: 30s, 2m), overridden by component_limits blocks")
+	rootCmd.PersistentFlags().String("component-memory-limit", "", "Maximum memory of each component (ex: 512MiB, 1GiB), overridden by component_limits blocks")
+	rootCmd.PersistentFlags().String("plugins-dir", "~/.config/barbe/plugins", "Directory of the plugin executables to load")
+	rootCmd.PersistentFlags().String("record", "", "Record everything the run consumed (input files, fetched files, env, state, files read back from containers) in the given file, to reproduce it with `barbe replay`. The file holds the env, variables and state in plaintext, including secrets")
+	rootCmd.PersistentFlags().StringArray("var", []string{}, "Value of a variable block, as name=value. Values of list and object variables are given as JSON")
+	rootCmd.PersistentFlags().StringArray("var-file", []string{}, "File of variable values, written as HCL attributes (name = value) or as a JSON object if it ends with .json. --var takes precedence")
 	rootCmd.PersistentFlags().StringArrayP("env", "e", []string{}, "Environment variables to pass to the templates, this can be either a key=value pair (FOO=bar), the name of a env variable to copy (FOO), or a file path to a .env file (./.env)")
 
 	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
@@ -33,6 +42,17 @@ func init() {
 		generateCmd,
 		applyCmd,
 		destroyCmd,
+		planCmd,
+		validateCmd,
+		watchCmd,
+		testCmd,
+		stateCmd,
+		lockCmd,
+		vendorCmd,
+		graphCmd,
+		explainCmd,
+		runCmd,
+		replayCmd,
 	)
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
 
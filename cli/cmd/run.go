@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"barbe/analytics"
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run STEP [GLOB...]",
+	Short: "Run the generate steps, then the given lifecycle step",
+	Long: "Run the generate steps, then the given lifecycle step.\n" +
+		"The step can be a builtin step or one declared in the template block, ex: `steps = [{ name = \"plan\", after = \"pre_do\" }]`",
+	Args:         cobra.MinimumNArgs(1),
+	Example:      "barbe run plan config.hcl\nbarbe run test **/*.hcl",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		step := args[0]
+		args = args[1:]
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running step '%s' with args: %v", step, args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		fileNames := make([]string, 0, len(allFiles))
+		for _, file := range allFiles {
+			fileNames = append(fileNames, file.Name)
+		}
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionStart",
+			EventProperties: map[string]interface{}{
+				"Files":       fileNames,
+				"FileCount":   len(allFiles),
+				"CurrentStep": step,
+			},
+		})
+
+		err = cliutils.IterateDirectories(ctx, core.MakeCommandRun, allFiles, func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			container, err := maker.RunStep(ctx, files, step)
+			if err != nil {
+				return errors.Wrap(err, "step '"+step+"' failed")
+			}
+			if viper.GetBool("debug-bags") {
+				cliutils.WriteDebugBags(ctx, maker.OutputDir, container)
+			}
+			return nil
+		})
+		if err != nil {
+			analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+				EventType: "ExecutionEnd",
+				EventProperties: map[string]interface{}{
+					"Error": err.Error(),
+				},
+			})
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionEnd",
+			EventProperties: map[string]interface{}{
+				"Success": true,
+			},
+		})
+		return nil
+	},
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"sort"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and edit the state stored by the state_store of the configuration",
+	Long: "Inspect and edit the state stored by the state_store of the configuration.\n" +
+		"The state is organized by scope key (usually the url of the component that wrote it) and then by key",
+}
+
+var stateListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List the scope keys in the state",
+	Args:         cobra.NoArgs,
+	Example:      "barbe state list --input config.hcl",
+	SilenceUsage: true,
+	RunE: runStateCommand(false, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		state := stateHandler.CurrentState()
+		scopeKeys := make([]string, 0, len(state.States))
+		for scopeKey := range state.States {
+			scopeKeys = append(scopeKeys, scopeKey)
+		}
+		sort.Strings(scopeKeys)
+		for _, scopeKey := range scopeKeys {
+			fmt.Printf("%s (%d keys)\n", scopeKey, len(state.States[scopeKey]))
+		}
+		return nil
+	}),
+}
+
+var stateShowCmd = &cobra.Command{
+	Use:          "show [SCOPE_KEY]",
+	Short:        "Print the whole state, or the state of a single scope, as JSON",
+	Args:         cobra.MaximumNArgs(1),
+	Example:      "barbe state show\nbarbe state show https://hub.barbe.app/anyfront/aws_s3.js:v0.2.1",
+	SilenceUsage: true,
+	RunE: runStateCommand(false, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		state := stateHandler.CurrentState()
+		if len(args) == 0 {
+			return printJson(state.States)
+		}
+		scope, ok := state.States[args[0]]
+		if !ok {
+			return errors.New("scope '" + args[0] + "' not found in state")
+		}
+		return printJson(scope)
+	}),
+}
+
+var stateGetCmd = &cobra.Command{
+	Use:          "get SCOPE_KEY KEY",
+	Short:        "Print the value of a key as JSON",
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: runStateCommand(false, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		value, ok := stateHandler.CurrentState().States[args[0]][args[1]]
+		if !ok {
+			return errors.New("key '" + args[1] + "' not found in scope '" + args[0] + "'")
+		}
+		return printJson(value)
+	}),
+}
+
+var stateSetCmd = &cobra.Command{
+	Use:          "set SCOPE_KEY KEY VALUE",
+	Short:        "Set the value of a key, VALUE is parsed as JSON and used as a plain string if it isn't valid JSON",
+	Args:         cobra.ExactArgs(3),
+	Example:      "barbe state set my_scope my_key '{\"foo\": \"bar\"}'\nbarbe state set my_scope my_key some_string",
+	SilenceUsage: true,
+	RunE: runStateCommand(true, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		var value any
+		if err := json.Unmarshal([]byte(args[2]), &value); err != nil {
+			value = args[2]
+		}
+		return stateHandler.ApplyStateAction(core.StateAction{
+			ScopeKey: args[0],
+			Action:   core.StateActionSet,
+			Key:      core.Ptr(args[1]),
+			SetValue: value,
+		})
+	}),
+}
+
+var stateRmCmd = &cobra.Command{
+	Use:          "rm SCOPE_KEY [KEY]",
+	Short:        "Remove a key from the state, or the whole scope if no key is given",
+	Args:         cobra.RangeArgs(1, 2),
+	SilenceUsage: true,
+	RunE: runStateCommand(true, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		if len(args) == 1 {
+			stateHandler.DeleteScope(args[0])
+			return nil
+		}
+		return stateHandler.ApplyStateAction(core.StateAction{
+			ScopeKey: args[0],
+			Action:   core.StateActionDelete,
+			Key:      core.Ptr(args[1]),
+		})
+	}),
+}
+
+var stateMvCmd = &cobra.Command{
+	Use:          "mv FROM_SCOPE_KEY TO_SCOPE_KEY",
+	Short:        "Rename a scope key, for example after the url of a component changed",
+	Args:         cobra.ExactArgs(2),
+	Example:      "barbe state mv https://hub.barbe.app/anyfront/aws_s3.js:v0.2.1 https://hub.barbe.app/anyfront/aws_s3.js:v0.3.0",
+	SilenceUsage: true,
+	RunE: runStateCommand(true, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		return stateHandler.MoveScope(args[0], args[1])
+	}),
+}
+
+var statePullCmd = &cobra.Command{
+	Use:          "pull [FILE]",
+	Short:        "Download the state as JSON, to stdout or to the given file",
+	Args:         cobra.MaximumNArgs(1),
+	Example:      "barbe state pull state.json",
+	SilenceUsage: true,
+	RunE: runStateCommand(false, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		b, err := json.MarshalIndent(stateHandler.CurrentState(), "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal state")
+		}
+		if len(args) == 0 {
+			fmt.Println(string(b))
+			return nil
+		}
+		err = os.WriteFile(args[0], b, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to write state at '"+args[0]+"'")
+		}
+		log.Ctx(ctx).Info().Msg("wrote state at '" + args[0] + "'")
+		return nil
+	}),
+}
+
+var statePushCmd = &cobra.Command{
+	Use:          "push FILE",
+	Short:        "Replace the whole state with the content of a JSON file, as written by pull",
+	Args:         cobra.ExactArgs(1),
+	Example:      "barbe state pull state.json\n# edit state.json\nbarbe state push state.json",
+	SilenceUsage: true,
+	RunE: runStateCommand(true, func(ctx context.Context, stateHandler *core.StateHandler, args []string) error {
+		b, err := os.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to read state at '"+args[0]+"'")
+		}
+		var stateHolder core.StateHolder
+		err = json.Unmarshal(b, &stateHolder)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse state at '"+args[0]+"'")
+		}
+		if stateHolder.FormatVersion != core.CurrentStateHolderFormatVersion {
+			return fmt.Errorf("unsupported state format version %d, expected %d", stateHolder.FormatVersion, core.CurrentStateHolderFormatVersion)
+		}
+		ok, err := logger.PromptUserYesNo(ctx, "This will replace the whole state with the content of '"+args[0]+"', continue?")
+		if err != nil {
+			return errors.Wrap(err, "failed to prompt user, use --auto-approve to skip the confirmation")
+		}
+		if !ok {
+			return errors.New("aborted")
+		}
+		stateHandler.ReplaceState(stateHolder)
+		return nil
+	}),
+}
+
+//runStateCommand loads the state from the state_store of the --input files and runs f on it,
+//if persist is true the state is persisted to all the stores after f
+func runStateCommand(persist bool, f func(ctx context.Context, stateHandler *core.StateHandler, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		maker, err := cliutils.LoadStateMaker(ctx, viper.GetStringSlice("input"))
+		if err != nil {
+			return err
+		}
+		defer maker.Close()
+		ctx = core.ContextWithMaker(ctx, maker)
+		err = f(ctx, maker.StateHandler, args)
+		if err != nil {
+			return err
+		}
+		if !persist {
+			return nil
+		}
+		maker.DryRun = false
+		err = maker.StateHandler.Persist()
+		if err != nil {
+			return errors.Wrap(err, "error persisting state")
+		}
+		log.Ctx(ctx).Info().Msg("state persisted")
+		return nil
+	}
+}
+
+func printJson(v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal state")
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func init() {
+	stateCmd.PersistentFlags().StringSlice("input", []string{"*.hcl"}, "Files (or globs) containing the state_store configuration")
+	stateCmd.AddCommand(
+		stateListCmd,
+		stateShowCmd,
+		stateGetCmd,
+		stateSetCmd,
+		stateRmCmd,
+		stateMvCmd,
+		statePullCmd,
+		statePushCmd,
+	)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var testCmd = &cobra.Command{
+	Use:          "test [GLOB...]",
+	Short:        "Run the barbe_test blocks of the given files, comparing the output of each component to its expected databags",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe test\nbarbe test **/*.test.hcl --update",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		if len(args) == 0 {
+			args = []string{"*.test.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		results, err := cliutils.RunComponentTests(ctx, allFiles, viper.GetBool("update"))
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, result := range results {
+			name := fmt.Sprintf("%s: %s", result.Case.File, result.Case.Name)
+			switch {
+			case result.Err != nil:
+				failed++
+				fmt.Printf("FAIL %s\n  %s\n", name, result.Err.Error())
+			case result.Updated:
+				fmt.Printf("UPDATED %s (%s)\n", name, result.Case.Expected)
+			case len(result.Diff) != 0:
+				failed++
+				fmt.Printf("FAIL %s\n", name)
+				for _, line := range result.Diff {
+					fmt.Println("  " + line)
+				}
+			default:
+				fmt.Printf("PASS %s\n", name)
+			}
+		}
+		if len(results) == 0 {
+			log.Ctx(ctx).Warn().Msgf("no barbe_test block found in %v", args)
+		}
+		if failed != 0 {
+			return errors.New(fmt.Sprintf("%d/%d test(s) failed", failed, len(results)))
+		}
+		return nil
+	},
+}
+
+func init() {
+	testCmd.Flags().Bool("update", false, "Overwrite the expected files with the actual output of the components")
+}
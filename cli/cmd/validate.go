@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"barbe/analytics"
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var validateCmd = &cobra.Command{
+	Use:          "validate [GLOB...]",
+	Short:        "Check the input files and the components up to the generate step, reporting problems with their location",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe validate config.hcl\nbarbe validate **/*.hcl",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		fileNames := make([]string, 0, len(allFiles))
+		for _, file := range allFiles {
+			fileNames = append(fileNames, file.Name)
+		}
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionStart",
+			EventProperties: map[string]interface{}{
+				"Files":       fileNames,
+				"FileCount":   len(allFiles),
+				"CurrentStep": "validate",
+			},
+		})
+
+		diags := core.Diagnostics{}
+		err = cliutils.IterateDirectories(ctx, core.MakeCommandGenerate, allFiles, func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			maker.DryRun = true
+			_, err := maker.Validate(ctx, files)
+			//keep going on the other directories so all the problems are reported at once
+			diags = append(diags, core.DiagnosticsFromError(err)...)
+			return nil
+		})
+		if err != nil {
+			analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+				EventType: "ExecutionEnd",
+				EventProperties: map[string]interface{}{
+					"Error": err.Error(),
+				},
+			})
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+
+		for _, diag := range diags {
+			fmt.Println(diag.String())
+		}
+		if diags.HasErrors() {
+			errorCount := 0
+			for _, diag := range diags {
+				if diag.Severity == core.DiagnosticSeverityError {
+					errorCount++
+				}
+			}
+			err = errors.New(fmt.Sprintf("validation failed with %d error(s)", errorCount))
+			analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+				EventType: "ExecutionEnd",
+				EventProperties: map[string]interface{}{
+					"Error": err.Error(),
+				},
+			})
+			return err
+		}
+		log.Ctx(ctx).Info().Msgf("%d file(s) validated", len(allFiles))
+
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionEnd",
+			EventProperties: map[string]interface{}{
+				"Success": true,
+			},
+		})
+		return nil
+	},
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var vendorCmd = &cobra.Command{
+	Use:          "vendor [GLOB...]",
+	Short:        "Download the manifests, components and files used by the configuration into the vendor directory, so barbe can run offline",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe vendor config.hcl\nbarbe vendor **/*.hcl --vendor-dir third_party/barbe",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx := lg.WithContext(cmd.Context())
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		allFiles, err := cliutils.ReadAllFilesMatching(ctx, args)
+		if err != nil {
+			lg.Error().Err(err).Msg("failed to read files")
+			return err
+		}
+
+		vendored, err := cliutils.VendorFiles(ctx, allFiles)
+		if err != nil {
+			return err
+		}
+		for _, url := range vendored {
+			log.Ctx(ctx).Debug().Msg("vendored '" + url + "'")
+		}
+		log.Ctx(ctx).Info().Msgf("vendored %d files into '%s'", len(vendored), viper.GetString("vendor-dir"))
+		return nil
+	},
+}
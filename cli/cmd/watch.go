@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"barbe/analytics"
+	"barbe/cli/cmd/cliutils"
+	"barbe/cli/logger"
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var watchCmd = &cobra.Command{
+	Use:          "watch [GLOB...]",
+	Short:        "Generate files based on the given configuration, and generate them again every time an input file changes",
+	Args:         cobra.ArbitraryArgs,
+	Example:      "barbe watch config.hcl --output dist\nBARBE_LOCAL=../components barbe watch **/*.hcl --env .dev.env",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			panic(err)
+		}
+
+		lg, closer := logger.New()
+		defer closer()
+		ctx, stop := signal.NotifyContext(lg.WithContext(cmd.Context()), os.Interrupt)
+		defer stop()
+
+		if len(args) == 0 {
+			args = []string{"*.hcl"}
+		}
+		log.Ctx(ctx).Debug().Msgf("running with args: %v", args)
+
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionStart",
+			EventProperties: map[string]interface{}{
+				"Files":       args,
+				"CurrentStep": "watch",
+			},
+		})
+
+		err := cliutils.WatchDirectories(ctx, core.MakeCommandGenerate, args, viper.GetDuration("interval"), func(files []fetcher.FileDescription, ctx context.Context, maker *core.Maker) error {
+			container, err := maker.Make(ctx, files)
+			if err != nil {
+				return errors.Wrap(err, "generation failed")
+			}
+			if viper.GetBool("debug-bags") {
+				cliutils.WriteDebugBags(ctx, maker.OutputDir, container)
+			}
+			return nil
+		})
+		if err != nil {
+			analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+				EventType: "ExecutionEnd",
+				EventProperties: map[string]interface{}{
+					"Error": err.Error(),
+				},
+			})
+			lg.Error().Err(err).Msg("")
+			return err
+		}
+		analytics.QueueEvent(ctx, analytics.AnalyticsEvent{
+			EventType: "ExecutionEnd",
+			EventProperties: map[string]interface{}{
+				"Success": true,
+			},
+		})
+		return nil
+	},
+}
+
+func init() {
+	watchCmd.Flags().Duration("interval", 300*time.Millisecond, "How often the input files are checked for changes")
+}
@@ -105,24 +105,24 @@ func populateAwsSession(ctx context.Context, dataBag core.DataBag) (core.DataBag
 			ObjectConst: []core.ObjectConstItem{
 				{
 					Key: "access_key_id",
-					Value: core.SyntaxToken{
+					Value: core.MarkSensitive(core.SyntaxToken{
 						Type:  core.TokenTypeLiteralValue,
 						Value: creds.AccessKeyID,
-					},
+					}),
 				},
 				{
 					Key: "secret_access_key",
-					Value: core.SyntaxToken{
+					Value: core.MarkSensitive(core.SyntaxToken{
 						Type:  core.TokenTypeLiteralValue,
 						Value: creds.SecretAccessKey,
-					},
+					}),
 				},
 				{
 					Key: "session_token",
-					Value: core.SyntaxToken{
+					Value: core.MarkSensitive(core.SyntaxToken{
 						Type:  core.TokenTypeLiteralValue,
 						Value: creds.SessionToken,
-					},
+					}),
 				},
 			},
 		},
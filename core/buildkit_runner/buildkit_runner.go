@@ -7,9 +7,10 @@ import (
 	"barbe/core/buildkit_runner/buildkitd"
 	"barbe/core/buildkit_runner/socketprovider"
 	"barbe/core/fetcher"
-	"barbe/core/state_display"
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/containerd/containerd/platforms"
 	"github.com/docker/cli/cli/config"
 	bk "github.com/moby/buildkit/client"
@@ -21,7 +22,6 @@ import (
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 	"io"
 	"os"
 	"path"
@@ -35,6 +35,26 @@ const bagName = "buildkit_run_in_container"
 type BuildkitRunner struct {
 	mutex           sync.Mutex
 	alreadyExecuted map[string]struct{}
+	plannedRuns     []PlannedRun
+
+	//the buildkit daemon is found (or started) once per runner
+	bkMutex    sync.Mutex
+	bkHost     *string
+	bkPlatform *specs.Platform
+}
+
+//PlannedRun describes a buildkit_run_in_container that would have been executed if the maker wasn't in dry run mode
+type PlannedRun struct {
+	Step        string
+	DisplayName string
+	Message     string `json:",omitempty"`
+	//sha256 of the dockerfile content
+	DockerfileHash string
+	//file name -> sha256 of the file content
+	InputFiles map[string]string
+	//path in the container -> path in the output directory
+	ExportedFiles map[string]string
+	ReadBackFiles []string `json:",omitempty"`
 }
 
 func NewBuildkitRunner() *BuildkitRunner {
@@ -48,6 +68,15 @@ func (t *BuildkitRunner) Name() string {
 	return "buildkit_runner"
 }
 
+//PlannedRuns returns all the runs that were recorded while the maker was in dry run mode
+func (t *BuildkitRunner) PlannedRuns() []PlannedRun {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	output := make([]PlannedRun, len(t.plannedRuns))
+	copy(output, t.plannedRuns)
+	return output
+}
+
 func (t *BuildkitRunner) Transform(ctx context.Context, data core.ConfigContainer) (core.ConfigContainer, error) {
 	runnerConfigs := make([]runnerConfig, 0)
 	for resourceType, m := range data.DataBags {
@@ -73,9 +102,16 @@ func (t *BuildkitRunner) Transform(ctx context.Context, data core.ConfigContaine
 				if err != nil {
 					return core.ConfigContainer{}, errors.Wrap(err, "error compiling buildkit_run_in_container")
 				}
+				c.DatabagName = databag.Name
 				if c.DisplayName == "" {
 					c.DisplayName = databag.Name
 				}
+				for _, p := range databag.Provenance {
+					if p.Kind == core.ProvenanceComponent {
+						c.Component = p.Source
+						break
+					}
+				}
 				runnerConfigs = append(runnerConfigs, c)
 			}
 		}
@@ -84,7 +120,23 @@ func (t *BuildkitRunner) Transform(ctx context.Context, data core.ConfigContaine
 		return *core.NewConfigContainer(), nil
 	}
 
-	if bkHost == nil {
+	maker := core.MakerFromContext(ctx)
+	if maker.Replaying != nil {
+		return replayRunners(ctx, maker, runnerConfigs)
+	}
+	if maker.DryRun {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		for _, rConf := range runnerConfigs {
+			t.plannedRuns = append(t.plannedRuns, makePlannedRun(maker.CurrentStep, rConf))
+		}
+		return *core.NewConfigContainer(), nil
+	}
+
+	t.bkMutex.Lock()
+	bkStarted := t.bkHost != nil
+	t.bkMutex.Unlock()
+	if !bkStarted {
 		err := buildkitd.CheckDocker(ctx)
 		if err != nil {
 			errStr := strings.ToLower(err.Error())
@@ -107,12 +159,19 @@ func (t *BuildkitRunner) Transform(ctx context.Context, data core.ConfigContaine
 		}
 	}
 
-	eg := errgroup.Group{}
+	eg, egCtx := core.NewErrorGroup(ctx, 0)
 	output := core.NewConcurrentConfigContainer()
 	for _, rConf := range runnerConfigs {
 		rConf := rConf
 		eg.Go(func() error {
-			return executeRunner(ctx, rConf, output)
+			err := t.executeRunner(egCtx, rConf, output)
+			if err != nil {
+				err = errors.Wrap(err, "error running '"+rConf.DisplayName+"'")
+				if rConf.Component != "" {
+					err = core.NewComponentError(rConf.Component, maker.CurrentStep, err)
+				}
+			}
+			return err
 		})
 	}
 	err := eg.Wait()
@@ -133,6 +192,11 @@ type runnerConfig struct {
 
 	Dockerfile *string
 	NoCache    bool
+
+	//Component is the component that produced the buildkit_run_in_container databag, if known
+	Component string
+	//DatabagName identifies the run, it's only executed once per databag name
+	DatabagName string
 }
 
 func parseRunnerConfig(ctx context.Context, objConst []core.ObjectConstItem) (runnerConfig, error) {
@@ -296,6 +360,28 @@ func parseRunnerConfig(ctx context.Context, objConst []core.ObjectConstItem) (ru
 	return output, nil
 }
 
+func makePlannedRun(step core.MakeLifecycleStep, rConf runnerConfig) PlannedRun {
+	hash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	planned := PlannedRun{
+		Step:          step,
+		DisplayName:   rConf.DisplayName,
+		Message:       rConf.Message,
+		InputFiles:    make(map[string]string, len(rConf.InputFiles)),
+		ExportedFiles: rConf.ExportedFiles,
+		ReadBackFiles: rConf.ReadBackFiles,
+	}
+	if rConf.Dockerfile != nil {
+		planned.DockerfileHash = hash(*rConf.Dockerfile)
+	}
+	for name, content := range rConf.InputFiles {
+		planned.InputFiles[name] = hash(content)
+	}
+	return planned
+}
+
 func buildLlbDefinition(ctx context.Context, runnerConfig runnerConfig, bkgwClient bkgw.Client, platform *specs.Platform) (*llb.State, error) {
 	dockerOpts := dockerfile2llb.ConvertOpt{
 		Excludes:       runnerConfig.Excludes,
@@ -343,7 +429,7 @@ func buildLlbDefinition(ctx context.Context, runnerConfig runnerConfig, bkgwClie
 func makeSolveOptions(ctx context.Context, runnerConfig runnerConfig) bk.SolveOpt {
 	opts := bk.SolveOpt{
 		LocalDirs: map[string]string{
-			"src": ".",
+			"src": core.MakerFromContext(ctx).ResolvePath("."),
 		},
 		Session: []session.Attachable{
 			socketprovider.NewDockerSocketProvider(),
@@ -355,19 +441,19 @@ func makeSolveOptions(ctx context.Context, runnerConfig runnerConfig) bk.SolveOp
 		opts.Exports = []bk.ExportEntry{
 			{
 				Type:      bk.ExporterLocal,
-				OutputDir: ctx.Value("maker").(*core.Maker).OutputDir,
+				OutputDir: core.MakerFromContext(ctx).OutputDir,
 			},
 		}
 	}
 	return opts
 }
 
-func executeRunner(ctx context.Context, rConf runnerConfig, output *core.ConcurrentConfigContainer) (e error) {
-	maker := ctx.Value("maker").(*core.Maker)
+func (t *BuildkitRunner) executeRunner(ctx context.Context, rConf runnerConfig, output *core.ConcurrentConfigContainer) (e error) {
+	maker := core.MakerFromContext(ctx)
 	outputDir := maker.OutputDir
-	state_display.GlobalState.StartMinorStep(maker.CurrentStep, rConf.DisplayName)
+	maker.StateDisplay.StartMinorStep(maker.CurrentStep, rConf.DisplayName)
 	defer func() {
-		state_display.GlobalState.EndMinorStepWith(maker.CurrentStep, rConf.DisplayName, e != nil)
+		maker.StateDisplay.EndMinorStepWith(maker.CurrentStep, rConf.DisplayName, e != nil)
 	}()
 
 	if rConf.Message != "" {
@@ -384,20 +470,21 @@ func executeRunner(ctx context.Context, rConf runnerConfig, output *core.Concurr
 		}
 	}
 
-	bkClient, err := getBuildkitClient(ctx)
+	bkClient, err := t.getBuildkitClient(ctx)
 	if err != nil {
 		return err
 	}
-	platform, err := detectPlatform(ctx, bkClient)
+	platform, err := t.detectPlatform(ctx, bkClient)
 	if err != nil {
 		return err
 	}
 
 	logBuffer := strings.Builder{}
 	dispatchLog := func(s string) {
+		s = maker.RedactString(s)
 		logBuffer.WriteString(s + "\n")
 		log.Ctx(ctx).Debug().Msg(s)
-		state_display.GlobalState.AddLogLine(maker.CurrentStep, rConf.DisplayName, s)
+		maker.StateDisplay.AddLogLine(maker.CurrentStep, rConf.DisplayName, s)
 	}
 
 	buildFunc := func(ctx context.Context, c bkgw.Client) (*bkgw.Result, error) {
@@ -422,6 +509,10 @@ func executeRunner(ctx context.Context, rConf runnerConfig, output *core.Concurr
 
 	err = executeLlbDefinition(ctx, rConf.DisplayName, bkClient, makeSolveOptions(ctx, rConf), dispatchLog, buildFunc)
 	if err != nil {
+		//the failed command is part of the message, it can contain secrets
+		if redacted := maker.RedactString(err.Error()); redacted != err.Error() {
+			err = errors.New(redacted)
+		}
 		logFilePath := path.Join(outputDir, strings.ReplaceAll(rConf.DisplayName, "/", "_")+".log")
 		errWrite := os.WriteFile(logFilePath, []byte(logBuffer.String()), 0644)
 		if errWrite != nil {
@@ -445,8 +536,16 @@ func executeRunner(ctx context.Context, rConf runnerConfig, output *core.Concurr
 		})
 	}
 
+	if maker.Recording != nil {
+		maker.Recording.RecordReadBackFiles(rConf.DatabagName, readBackFiles)
+	}
+	return parseReadBackFiles(ctx, maker, readBackFiles, output)
+}
+
+func parseReadBackFiles(ctx context.Context, maker *core.Maker, readBackFiles []fetcher.FileDescription, output *core.ConcurrentConfigContainer) error {
 	tmp := core.NewConfigContainer()
-	err = maker.ParseFiles(ctx, readBackFiles, tmp)
+	//the files are written by the container, a "${" in them is not a template
+	err := maker.ParseFiles(core.ContextWithLiteralFiles(ctx), readBackFiles, tmp)
 	if err != nil {
 		return errors.Wrap(err, "error parsing read back files")
 	}
@@ -457,6 +556,19 @@ func executeRunner(ctx context.Context, rConf runnerConfig, output *core.Concurr
 	return nil
 }
 
+//replayRunners doesn't run anything, it gives back the files that were read back when the runs were recorded
+func replayRunners(ctx context.Context, maker *core.Maker, runnerConfigs []runnerConfig) (core.ConfigContainer, error) {
+	output := core.NewConcurrentConfigContainer()
+	for _, rConf := range runnerConfigs {
+		log.Ctx(ctx).Debug().Msgf("replaying '%s'", rConf.DisplayName)
+		err := parseReadBackFiles(ctx, maker, maker.Replaying.RecordedReadBackFiles(rConf.DatabagName), output)
+		if err != nil {
+			return core.ConfigContainer{}, errors.Wrap(err, "error replaying '"+rConf.DisplayName+"'")
+		}
+	}
+	return *output.Container(), nil
+}
+
 func executeLlbDefinition(ctx context.Context, name string, bkClient *bk.Client, opts bk.SolveOpt, logger func(logLine string), buildFunc bkgw.BuildFunc) error {
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
@@ -509,11 +621,10 @@ func executeLlbDefinition(ctx context.Context, name string, bkClient *bk.Client,
 	return nil
 }
 
-var bkHost *string
-var bkPlatform *specs.Platform
-
-func getBuildkitClient(ctx context.Context) (*bk.Client, error) {
-	if bkHost == nil {
+func (t *BuildkitRunner) getBuildkitClient(ctx context.Context) (*bk.Client, error) {
+	t.bkMutex.Lock()
+	defer t.bkMutex.Unlock()
+	if t.bkHost == nil {
 		host := os.Getenv("BUILDKIT_HOST")
 		if host == "" {
 			h, err := buildkitd.Start(ctx)
@@ -522,18 +633,20 @@ func getBuildkitClient(ctx context.Context) (*bk.Client, error) {
 			}
 			host = h
 		}
-		bkHost = &host
+		t.bkHost = &host
 	}
-	c, err := bk.New(ctx, *bkHost, bk.WithFailFast())
+	c, err := bk.New(ctx, *t.bkHost, bk.WithFailFast())
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating buildkit client")
 	}
 	return c, nil
 }
 
-func detectPlatform(ctx context.Context, client *bk.Client) (specs.Platform, error) {
-	if bkPlatform != nil {
-		return *bkPlatform, nil
+func (t *BuildkitRunner) detectPlatform(ctx context.Context, client *bk.Client) (specs.Platform, error) {
+	t.bkMutex.Lock()
+	defer t.bkMutex.Unlock()
+	if t.bkPlatform != nil {
+		return *t.bkPlatform, nil
 	}
 	w, err := client.ListWorkers(ctx)
 	if err != nil {
@@ -542,10 +655,10 @@ func detectPlatform(ctx context.Context, client *bk.Client) (specs.Platform, err
 
 	if len(w) > 0 && len(w[0].Platforms) > 0 {
 		dPlatform := w[0].Platforms[0]
-		bkPlatform = &dPlatform
+		t.bkPlatform = &dPlatform
 		return dPlatform, nil
 	}
 	tmp := platforms.DefaultSpec()
-	bkPlatform = &tmp
-	return *bkPlatform, nil
+	t.bkPlatform = &tmp
+	return *t.bkPlatform, nil
 }
@@ -14,6 +14,15 @@ type Executable struct {
 	//files are plain config files that are added to the files to parse
 	Files      []fetcher.FileDescription
 	Components []fetcher.FileDescription
+	//Declarations are keyed by the name of the component's FileDescription
+	Declarations map[string]ComponentDeclaration
+	//Limits are keyed by the name of the component's FileDescription, DefaultLimits come from the unnamed component_limits block
+	Limits        map[string]ComponentLimits
+	DefaultLimits ComponentLimits
+	//Steps are the lifecycle steps declared in the template block
+	Steps []LifecycleStepDeclaration
+	//Plugins are paths to plugin executables declared in the template block
+	Plugins []string
 }
 
 type SyntaxTokenType = string
@@ -161,6 +170,10 @@ func (t SyntaxToken) IsSuperSetOf(other SyntaxToken) bool {
 	if t.Type != other.Type {
 		return false
 	}
+	//the sensitive mark would be lost if other was ignored
+	if IsSensitive(other) && !IsSensitive(t) {
+		return false
+	}
 	switch t.Type {
 	default:
 		return false
@@ -174,6 +187,18 @@ func (t SyntaxToken) IsSuperSetOf(other SyntaxToken) bool {
 }
 
 func (t SyntaxToken) MergeWith(other SyntaxToken) (SyntaxToken, error) {
+	merged, err := t.mergeWith(other)
+	if err != nil {
+		return SyntaxToken{}, err
+	}
+	//a sensitive value stays sensitive when it's merged with, or replaced by, a token that isn't marked
+	if (IsSensitive(t) || IsSensitive(other)) && !IsSensitive(merged) {
+		merged = MarkSensitive(merged)
+	}
+	return merged, nil
+}
+
+func (t SyntaxToken) mergeWith(other SyntaxToken) (SyntaxToken, error) {
 	if t.Type != other.Type {
 		return other, nil
 	}
@@ -205,6 +230,8 @@ type DataBag struct {
 	Type   string
 	Labels []string
 	Value  SyntaxToken
+	//Provenance is not serialized, so the components and the outputs don't see it
+	Provenance []Provenance `json:"-"`
 }
 
 func (d DataBag) MergeWith(other DataBag) (DataBag, error) {
@@ -215,6 +242,7 @@ func (d DataBag) MergeWith(other DataBag) (DataBag, error) {
 		return other, errors.New("cannot merge data bags with different names")
 	}
 	if d.Value.Type == "" {
+		other.Provenance = mergeProvenance(d.Provenance, other.Provenance)
 		return other, nil
 	}
 	var err error
@@ -222,6 +250,7 @@ func (d DataBag) MergeWith(other DataBag) (DataBag, error) {
 	if err != nil {
 		return other, errors.Wrap(err, "error merging databag value")
 	}
+	d.Provenance = mergeProvenance(d.Provenance, other.Provenance)
 	return d, nil
 }
 
@@ -7,13 +7,13 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 	"os"
 	"path"
 	"reflect"
 )
 
 func (maker *Maker) ApplyComponents(ctx context.Context, container *ConfigContainer) error {
+	tracker := convergenceTracker{}
 	for i := 0; i < maxComponentLoops; i++ {
 		beforeApply := container.Clone()
 		if os.Getenv("BARBE_VERBOSE") == "1" {
@@ -29,32 +29,51 @@ func (maker *Maker) ApplyComponents(ctx context.Context, container *ConfigContai
 			comparison.DeleteDataBagsOfType(t)
 		}
 		if comparison.IsEmpty() {
-			break
+			return nil
 		}
+		tracker.record(i, databagsLike(*beforeApply, *comparison), *comparison, *comparison)
 	}
-	return nil
+	return tracker.error(maker.CurrentStep, maxComponentLoops)
 }
 
 func (maker *Maker) applyComponentsLoop(ctx context.Context, container *ConfigContainer) error {
 	componentInput := container
+	tracker := convergenceTracker{}
+	//merges the changed databags in the container, keeping track of what changed in case the loop never ends
+	mergeChanges := func(iteration int, changed ConfigContainer) error {
+		before := databagsLike(*container, changed)
+		err := container.MergeWith(changed)
+		if err != nil {
+			return errors.Wrap(err, "error merging databags")
+		}
+		tracker.record(iteration, before, databagsLike(*container, changed), changed)
+		return nil
+	}
 	for i := 0; i < maxComponentLoops; i++ {
 		if os.Getenv("BARBE_VERBOSE") == "1" {
 			log.Ctx(ctx).Debug().Msgf("applying components, loop %d", i)
 		}
-		eg := errgroup.Group{}
-		eg.SetLimit(50)
+		eg, egCtx := NewErrorGroup(ctx, 50)
 		newDatabags := NewConcurrentConfigContainer()
+		//after the first loop the components only receive the databags produced by the previous loop
+		onlyNewInput := i > 0
 		for i := range maker.Executable.Components {
 			component := maker.Executable.Components[i]
+			if onlyNewInput && !maker.componentAffected(component.Name, *componentInput) {
+				continue
+			}
 			eg.Go(func() error {
 				input := componentInput.Clone()
-				output, err := maker.ApplyComponent(ctx, component, *input)
+				output, err := maker.ApplyComponent(egCtx, component, *input)
 				if err != nil {
-					return err
+					return NewComponentError(component.Name, maker.CurrentStep, err)
 				}
 				if output.IsEmpty() {
 					return nil
 				}
+				if onlyNewInput {
+					maker.Graph.AddTriggered(component.Name, *input, maker.CurrentStep)
+				}
 				err = newDatabags.MergeWith(output)
 				if err != nil {
 					return errors.Wrap(err, "error merging databags")
@@ -62,7 +81,6 @@ func (maker *Maker) applyComponentsLoop(ctx context.Context, container *ConfigCo
 				return nil
 			})
 		}
-		//TODO this hangs on errors ?
 		err := eg.Wait()
 		if err != nil {
 			return err
@@ -98,24 +116,24 @@ func (maker *Maker) applyComponentsLoop(ctx context.Context, container *ConfigCo
 				comparison.DeleteDataBagsOfType(t)
 			}
 			if comparison.IsEmpty() {
-				break
+				return nil
 			}
-			err = container.MergeWith(*comparison)
+			err = mergeChanges(i, *comparison)
 			if err != nil {
-				return errors.Wrap(err, "error merging databags")
+				return err
 			}
 			err = componentInput.MergeWith(*comparison)
 			if err != nil {
 				return errors.Wrap(err, "error merging databags")
 			}
 		} else {
-			err = container.MergeWith(*comparison)
+			err = mergeChanges(i, *comparison)
 			if err != nil {
-				return errors.Wrap(err, "error merging databags")
+				return err
 			}
 		}
 	}
-	return nil
+	return tracker.error(maker.CurrentStep, maxComponentLoops)
 }
 
 //this removes all the bags that are in `container` from `newDatabags`
@@ -153,7 +171,11 @@ func (maker *Maker) ApplyComponent(ctx context.Context, file fetcher.FileDescrip
 	//var task *trace.Task
 	var span opentracing.Span
 	if os.Getenv("BARBE_TRACE") != "" {
-		b, err := json.Marshal(input)
+		redacted, err := input.Redacted()
+		if err != nil {
+			return ConfigContainer{}, errors.Wrap(err, "error redacting input for trace")
+		}
+		b, err := json.Marshal(redacted)
 		if err != nil {
 			return ConfigContainer{}, errors.Wrap(err, "error marshalling input for trace")
 		}
@@ -171,7 +193,11 @@ func (maker *Maker) ApplyComponent(ctx context.Context, file fetcher.FileDescrip
 		//	return ConfigContainer{}, errors.Wrap(err, "error marshalling input for trace")
 		//}
 		//trace.Log(traceCtx, "input", string(b))
-		//trace.Log(traceCtx, "command", ctx.Value("maker").(*Maker).CurrentStep)
+		//trace.Log(traceCtx, "command", MakerFromContext(ctx).CurrentStep)
+	}
+	markComponentUncacheable(ctx)
+	if parent, ok := ctx.Value(StateScopeContextKey).(*StateScope); ok {
+		maker.Graph.AddEdge(GraphNodeComponent, parent.Name, GraphNodeComponent, file.Name, GraphEdgeImports, maker.CurrentStep)
 	}
 	ctx = ContextWithScope(ctx, file.Name)
 
@@ -181,28 +207,33 @@ func (maker *Maker) ApplyComponent(ctx context.Context, file fetcher.FileDescrip
 	if os.Getenv("BARBE_VERBOSE") == "1" {
 		log.Ctx(ctx).Debug().Msg("applying component '" + file.Name)
 	}
-	output := NewConfigContainer()
-	for _, engine := range maker.Templaters {
-		//log.Ctx(ctx).Debug().Msg("applying template engine: '" + engine.Name() + "'")
-		//t := time.Now()
-
-		partialOutput, err := engine.Apply(ctx, maker, input, file)
-		//log.Ctx(ctx).Debug().Msgf("template engine '%s' took: %v", engine.Name(), time.Since(t))
-		if err != nil {
-			return ConfigContainer{}, errors.Wrap(err, "from template engine '"+engine.Name()+"'")
-		}
-
-		err = output.MergeWith(partialOutput)
-		if err != nil {
-			return ConfigContainer{}, errors.Wrap(err, "merging output")
-		}
+	output, err := maker.applyTemplaters(ctx, file, input)
+	if err != nil {
+		return ConfigContainer{}, err
+	}
+	err = maker.extractComponentDeclaration(ctx, file.Name, output)
+	if err != nil {
+		return ConfigContainer{}, err
 	}
-	err := maker.TransformInPlace(ctx, output)
+	maker.logUndeclaredOutputs(ctx, file.Name, *output)
+	maker.RegisterSensitiveValues(*output)
+	//recorded before the transformers run, the bags of imported components are attributed to the imported component
+	output.SetMissingProvenance(Provenance{
+		Kind:   ProvenanceComponent,
+		Source: file.Name,
+		Step:   maker.CurrentStep,
+	})
+	maker.Graph.AddProduced(GraphNodeComponent, file.Name, *output, maker.CurrentStep)
+	err = maker.TransformInPlace(ctx, output)
 	if err != nil {
 		return ConfigContainer{}, err
 	}
 	if os.Getenv("BARBE_TRACE") != "" {
-		b, err := json.Marshal(output)
+		redacted, err := output.Redacted()
+		if err != nil {
+			return ConfigContainer{}, errors.Wrap(err, "error redacting output for trace")
+		}
+		b, err := json.Marshal(redacted)
 		if err != nil {
 			return ConfigContainer{}, errors.Wrap(err, "error marshalling output for trace")
 		}
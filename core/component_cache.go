@@ -0,0 +1,263 @@
+package core
+
+import (
+	"barbe/core/fetcher"
+	"barbe/core/version"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//the outputs depend on how the templaters execute components, so they are not shared across versions
+const componentCachePath = "~/.cache/barbe/components_for_" + version.Version
+
+//componentUncacheableContextKey holds an *int32 set to 1 when the output of the component running in the templaters must not be cached
+const componentUncacheableContextKey = "barbe_component_uncacheable"
+
+//defaultComponentCacheMaxSize is the size of the cache directory above which the least recently used entries are evicted
+const defaultComponentCacheMaxSize = 512 * 1024 * 1024
+
+//ComponentCache stores the output of the templaters for a component, keyed on everything the output depends on:
+//the component's name and content, its input, the env, the output directory, the command and lifecycle step, and the state of its scope.
+//Only the templaters are skipped on a hit, the transformers (and their side effects) still run on the output.
+//Outputs of components that import other components or that run the transformers themselves are not cached,
+//since the side effects of these transformers happen within the templaters.
+//Components whose input or output holds sensitive values are not cached either, the entries are not encrypted
+type ComponentCache struct {
+	Dir string
+	//MaxSize in bytes, the least recently used entries are evicted when the entries are larger than this
+	MaxSize int64
+
+	size       int64
+	evictMutex sync.Mutex
+}
+
+func NewComponentCache() (*ComponentCache, error) {
+	dir, err := homedir.Expand(componentCachePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to expand component cache path")
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create component cache dir")
+	}
+	cache := &ComponentCache{
+		Dir:     dir,
+		MaxSize: defaultComponentCacheMaxSize,
+	}
+	err = cache.evict()
+	if err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+//Get returns false on a miss, unreadable entries are treated as misses
+func (c *ComponentCache) Get(key string) (ConfigContainer, bool) {
+	b, err := os.ReadFile(path.Join(c.Dir, key+".json"))
+	if err != nil {
+		return ConfigContainer{}, false
+	}
+	output := NewConfigContainer()
+	err = json.Unmarshal(b, output)
+	if err != nil {
+		return ConfigContainer{}, false
+	}
+	//the modification time tells the eviction which entries were used recently
+	now := time.Now()
+	_ = os.Chtimes(path.Join(c.Dir, key+".json"), now, now)
+	return *output, true
+}
+
+func (c *ComponentCache) Put(key string, output ConfigContainer) error {
+	b, err := json.Marshal(output)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal component output")
+	}
+	//written to a temporary file first so concurrent runs never read a partial entry
+	tmp, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create component cache entry")
+	}
+	_, err = tmp.Write(b)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to write component cache entry")
+	}
+	err = os.Rename(tmp.Name(), path.Join(c.Dir, key+".json"))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to write component cache entry")
+	}
+	if atomic.AddInt64(&c.size, int64(len(b))) > c.MaxSize {
+		return c.evict()
+	}
+	return nil
+}
+
+//evict removes the least recently used entries until the entries fit in MaxSize
+func (c *ComponentCache) evict() error {
+	c.evictMutex.Lock()
+	defer c.evictMutex.Unlock()
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list component cache entries")
+	}
+	entries := make([]fs.FileInfo, 0, len(dirEntries))
+	size := int64(0)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			//removed by a concurrent run
+			continue
+		}
+		entries = append(entries, info)
+		size += info.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, entry := range entries {
+		if size <= c.MaxSize {
+			break
+		}
+		err = os.Remove(path.Join(c.Dir, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to evict component cache entry")
+		}
+		size -= entry.Size()
+	}
+	atomic.StoreInt64(&c.size, size)
+	return nil
+}
+
+//componentCacheKey must be called with the component's scope in ctx
+func (maker *Maker) componentCacheKey(ctx context.Context, file fetcher.FileDescription, input ConfigContainer) (string, error) {
+	hash := sha256.New()
+	write := func(label string, v any) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal "+label+" for the component cache key")
+		}
+		hash.Write([]byte(label))
+		hash.Write([]byte{0})
+		hash.Write(b)
+		hash.Write([]byte{0})
+		return nil
+	}
+	//the name is given to the component (ex: BARBE_RUNNING_FILE) and is the key of its state scope
+	err := write("name", file.Name)
+	if err != nil {
+		return "", err
+	}
+	err = write("component", fetcher.ContentSha256(file.Content))
+	if err != nil {
+		return "", err
+	}
+	err = write("input", canonicalDatabags(input))
+	if err != nil {
+		return "", err
+	}
+	//maps are marshalled with sorted keys
+	err = write("env", maker.Env)
+	if err != nil {
+		return "", err
+	}
+	err = write("output_dir", maker.OutputDir)
+	if err != nil {
+		return "", err
+	}
+	err = write("command", maker.Command+"/"+maker.CurrentStep)
+	if err != nil {
+		return "", err
+	}
+	err = write("state", maker.StateHandler.CurrentState().States[ContextScopeKey(ctx)])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//canonicalDatabags lists the databags in a stable order, the groups of a container have no defined order
+func canonicalDatabags(container ConfigContainer) []DataBag {
+	output := make([]DataBag, 0)
+	for _, bagType := range sortedUnion(mapKeys(container.DataBags), nil) {
+		for _, bagName := range sortedUnion(mapKeys(container.DataBags[bagType]), nil) {
+			group := append(DataBagGroup{}, container.DataBags[bagType][bagName]...)
+			sort.SliceStable(group, func(i, j int) bool {
+				return strings.Join(group[i].Labels, ".") < strings.Join(group[j].Labels, ".")
+			})
+			output = append(output, group...)
+		}
+	}
+	return output
+}
+
+//applyTemplaters runs the templaters on the component, or returns their cached output
+func (maker *Maker) applyTemplaters(ctx context.Context, file fetcher.FileDescription, input ConfigContainer) (*ConfigContainer, error) {
+	cacheKey := ""
+	if maker.ComponentCache != nil {
+		key, err := maker.componentCacheKey(ctx, file, input)
+		if err != nil {
+			log.Ctx(ctx).Debug().Err(err).Msg("component cache disabled for '" + file.Name + "'")
+		} else if cached, ok := maker.ComponentCache.Get(key); ok {
+			return &cached, nil
+		} else {
+			cacheKey = key
+		}
+	}
+
+	uncacheable := int32(0)
+	ctx = context.WithValue(ctx, componentUncacheableContextKey, &uncacheable)
+	output := NewConfigContainer()
+	for _, engine := range maker.Templaters {
+		//log.Ctx(ctx).Debug().Msg("applying template engine: '" + engine.Name() + "'")
+		//t := time.Now()
+
+		partialOutput, err := engine.Apply(ctx, maker, input, file)
+		//log.Ctx(ctx).Debug().Msgf("template engine '%s' took: %v", engine.Name(), time.Since(t))
+		if err != nil {
+			return nil, errors.Wrap(err, "from template engine '"+engine.Name()+"'")
+		}
+
+		err = output.MergeWith(partialOutput)
+		if err != nil {
+			return nil, errors.Wrap(err, "merging output")
+		}
+	}
+
+	//the cache is written in plaintext, it must never hold a secret (ex: credentials given to the component or a secret it outputs)
+	if cacheKey != "" && atomic.LoadInt32(&uncacheable) == 0 && !input.ContainsSensitive() && !output.ContainsSensitive() {
+		err := maker.ComponentCache.Put(cacheKey, *output)
+		if err != nil {
+			log.Ctx(ctx).Debug().Err(err).Msg("failed to cache the output of '" + file.Name + "'")
+		}
+	}
+	return output, nil
+}
+
+//markComponentUncacheable must be called with the ctx of the component, when it imports another component or runs the transformers
+func markComponentUncacheable(ctx context.Context) {
+	if uncacheable, ok := ctx.Value(componentUncacheableContextKey).(*int32); ok {
+		atomic.StoreInt32(uncacheable, 1)
+	}
+}
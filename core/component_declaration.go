@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+//ComponentDeclarationDatabagType can be emitted by a component to declare what it consumes and produces,
+//ex: `{ Type: "barbe_component_declaration", Name: "", Value: { inputs: ["aws_s3"], outputs: ["cr_aws_s3_bucket"] } }`
+const ComponentDeclarationDatabagType = "barbe_component_declaration"
+
+//ComponentDeclaration lists the databag types a component consumes and produces.
+//A component with declared inputs is only re-run when databags of those types changed,
+//a component without declared inputs is re-run on every change
+type ComponentDeclaration struct {
+	Inputs  []string
+	Outputs []string
+	//Schemas are urls of files with the barbe_schema databags of the types the component understands,
+	//they are only read from manifests since the input is validated before the components run
+	Schemas []string
+}
+
+//interpretComponentEntries reads the components of a manifest or template block, each entry is either a url
+//or an object like `{ url = "...", inputs = ["..."], outputs = ["..."], schema = "..." }`, see interpretTemplateEntries for the scope and allEntries
+func interpretComponentEntries(token SyntaxToken, scope map[string]interface{}, allEntries bool) ([]string, map[string]ComponentDeclaration, error) {
+	entries, err := interpretTemplateEntries(token, scope, allEntries)
+	if err != nil {
+		return nil, nil, err
+	}
+	urls := make([]string, 0, len(entries))
+	declarations := map[string]ComponentDeclaration{}
+	for i, entry := range entries {
+		urls = append(urls, entry.Url)
+		if entry.Object == nil {
+			continue
+		}
+		declaration, err := interpretComponentDeclaration(*entry.Object)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("error parsing declaration of element %d", i))
+		}
+		declarations[entry.Url] = declaration
+	}
+	return urls, declarations, nil
+}
+
+func interpretComponentDeclaration(token SyntaxToken) (ComponentDeclaration, error) {
+	declaration := ComponentDeclaration{}
+	if token.Type != TokenTypeObjectConst {
+		return declaration, errors.New("component declaration must be an object")
+	}
+	for _, pair := range token.ObjectConst {
+		switch pair.Key {
+		case "inputs":
+			types, err := interpretAsStrArray(pair.Value)
+			if err != nil {
+				return declaration, errors.Wrap(err, "error parsing 'inputs'")
+			}
+			declaration.Inputs = append(make([]string, 0, len(types)), types...)
+		case "outputs":
+			types, err := interpretAsStrArray(pair.Value)
+			if err != nil {
+				return declaration, errors.Wrap(err, "error parsing 'outputs'")
+			}
+			declaration.Outputs = append(make([]string, 0, len(types)), types...)
+		case "schema", "schemas":
+			urls, err := interpretAsStrArray(pair.Value)
+			if err != nil {
+				return declaration, errors.Wrap(err, "error parsing '"+pair.Key+"'")
+			}
+			declaration.Schemas = append(declaration.Schemas, urls...)
+		}
+	}
+	return declaration, nil
+}
+
+//SetComponentDeclaration replaces the declaration of the component named componentName (as in Executable.Components)
+func (maker *Maker) SetComponentDeclaration(componentName string, declaration ComponentDeclaration) {
+	maker.declarationsMutex.Lock()
+	defer maker.declarationsMutex.Unlock()
+	if maker.Executable.Declarations == nil {
+		maker.Executable.Declarations = map[string]ComponentDeclaration{}
+	}
+	maker.Executable.Declarations[componentName] = declaration
+	maker.Graph.AddDeclaration(componentName, declaration)
+}
+
+func (maker *Maker) ComponentDeclaration(componentName string) (ComponentDeclaration, bool) {
+	maker.declarationsMutex.Lock()
+	defer maker.declarationsMutex.Unlock()
+	declaration, ok := maker.Executable.Declarations[componentName]
+	return declaration, ok
+}
+
+//extractComponentDeclaration removes the declaration databags from the output of a component and records them,
+//unless the component was already declared in its manifest
+func (maker *Maker) extractComponentDeclaration(ctx context.Context, componentName string, output *ConfigContainer) error {
+	bags := output.GetDataBagsOfType(ComponentDeclarationDatabagType)
+	if len(bags) == 0 {
+		return nil
+	}
+	output.DeleteDataBagsOfType(ComponentDeclarationDatabagType)
+	if _, ok := maker.ComponentDeclaration(componentName); ok {
+		return nil
+	}
+	declaration := ComponentDeclaration{}
+	for _, bag := range bags {
+		d, err := interpretComponentDeclaration(bag.Value)
+		if err != nil {
+			return errors.Wrap(err, "error parsing '"+ComponentDeclarationDatabagType+"' of component '"+componentName+"'")
+		}
+		if d.Inputs != nil {
+			declaration.Inputs = append(declaration.Inputs, d.Inputs...)
+		}
+		if d.Outputs != nil {
+			declaration.Outputs = append(declaration.Outputs, d.Outputs...)
+		}
+	}
+	log.Ctx(ctx).Debug().Msgf("component '%s' declared inputs %v and outputs %v", componentName, declaration.Inputs, declaration.Outputs)
+	maker.SetComponentDeclaration(componentName, declaration)
+	return nil
+}
+
+//componentAffected is true if the component should run given the databags that changed since the previous iteration
+func (maker *Maker) componentAffected(componentName string, changed ConfigContainer) bool {
+	declaration, ok := maker.ComponentDeclaration(componentName)
+	if !ok || declaration.Inputs == nil {
+		return true
+	}
+	for _, input := range declaration.Inputs {
+		if len(changed.DataBags[input]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//logUndeclaredOutputs logs the databag types a component produced without declaring them
+func (maker *Maker) logUndeclaredOutputs(ctx context.Context, componentName string, output ConfigContainer) {
+	declaration, ok := maker.ComponentDeclaration(componentName)
+	if !ok || declaration.Outputs == nil {
+		return
+	}
+	for bagType := range output.DataBags {
+		if contains(declaration.Outputs, bagType) || contains(BarbeStateTypes, bagType) {
+			continue
+		}
+		log.Ctx(ctx).Debug().Msgf("component '%s' produced '%s' databags but doesn't declare it as an output", componentName, bagType)
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//ComponentError is an error of a component, or of something it asked for like a container run, during a lifecycle step
+type ComponentError struct {
+	Component string
+	Step      MakeLifecycleStep
+	Err       error
+}
+
+func (e ComponentError) Error() string {
+	return fmt.Sprintf("component '%s' failed during '%s': %s", e.Component, e.Step, e.Err.Error())
+}
+
+func (e ComponentError) Unwrap() error {
+	return e.Err
+}
+
+//NewComponentError wraps err unless it's already an error of the same component
+func NewComponentError(componentName string, step MakeLifecycleStep, err error) error {
+	var existing ComponentError
+	if errors.As(err, &existing) && existing.Component == componentName && existing.Step == step {
+		return err
+	}
+	return ComponentError{
+		Component: componentName,
+		Step:      step,
+		Err:       err,
+	}
+}
+
+//MultiError is returned when several components failed, its message groups the errors by component and lifecycle step
+type MultiError struct {
+	Errors []error
+}
+
+func (e MultiError) Error() string {
+	groups := map[string][]string{}
+	for _, err := range e.Errors {
+		key := ""
+		msg := err.Error()
+		var componentErr ComponentError
+		if errors.As(err, &componentErr) {
+			key = fmt.Sprintf("component '%s' during '%s'", componentErr.Component, componentErr.Step)
+			msg = componentErr.Err.Error()
+		}
+		groups[key] = append(groups[key], msg)
+	}
+	keys := mapKeys(groups)
+	sort.Strings(keys)
+
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("%d errors occurred:", len(e.Errors)))
+	for _, key := range keys {
+		indent := "  "
+		if key != "" {
+			b.WriteString("\n  " + key + ":")
+			indent = "    "
+		}
+		for _, msg := range groups[key] {
+			b.WriteString("\n" + indent + "- " + strings.ReplaceAll(msg, "\n", "\n"+indent+"  "))
+		}
+	}
+	return b.String()
+}
+
+//ErrorGroup runs functions concurrently like errgroup.Group, but keeps every error instead of only the first one.
+//The context it returns is cancelled on the first error so the functions still running can stop early,
+//the errors caused by that cancellation are left out
+type ErrorGroup struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	mutex  sync.Mutex
+	errors []error
+}
+
+//NewErrorGroup returns a group running at most limit functions at once, limit <= 0 means no limit
+func NewErrorGroup(ctx context.Context, limit int) (*ErrorGroup, context.Context) {
+	groupCtx, cancel := context.WithCancel(ctx)
+	g := &ErrorGroup{
+		parent: ctx,
+		ctx:    groupCtx,
+		cancel: cancel,
+	}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g, groupCtx
+}
+
+//Go runs f in a new goroutine, it blocks while the limit of functions running is reached
+func (g *ErrorGroup) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+		err := f()
+		if err == nil {
+			return
+		}
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if g.ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			return
+		}
+		g.errors = append(g.errors, err)
+		g.cancel()
+	}()
+}
+
+//Wait waits for all the functions to return and returns nil, the only error, or a MultiError
+func (g *ErrorGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	switch len(g.errors) {
+	case 0:
+		return g.parent.Err()
+	case 1:
+		return g.errors[0]
+	default:
+		return MultiError{Errors: g.errors}
+	}
+}
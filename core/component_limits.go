@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+//ComponentLimitsDatabagType configures the limits of components, an unnamed block sets the default limits
+//and a block named after a component url overrides them for that component, ex:
+//`component_limits "https://hub.barbe.app/anyfront/aws_lambda.js" { timeout = "2m", memory_limit = "1GiB" }`
+const ComponentLimitsDatabagType = "component_limits"
+
+const (
+	ComponentLimitTimeout = "timeout"
+	ComponentLimitMemory  = "memory_limit"
+)
+
+//MinComponentMemoryLimit leaves room for the JavaScript runtime itself, a lower limit would fail before the component starts
+const MinComponentMemoryLimit = 32 * units.MiB
+
+//ComponentLimits are enforced by the templaters that can interrupt a component, a zero value means no limit
+type ComponentLimits struct {
+	Timeout time.Duration
+	//MemoryLimit is in bytes
+	MemoryLimit uint64
+}
+
+//Or returns the limits of c, with the limits not set in c taken from other
+func (c ComponentLimits) Or(other ComponentLimits) ComponentLimits {
+	if c.Timeout == 0 {
+		c.Timeout = other.Timeout
+	}
+	if c.MemoryLimit == 0 {
+		c.MemoryLimit = other.MemoryLimit
+	}
+	return c
+}
+
+//ComponentLimitError is returned by the templaters when a component is interrupted for exceeding one of its limits
+type ComponentLimitError struct {
+	Component string
+	Step      MakeLifecycleStep
+	//Limit is ComponentLimitTimeout or ComponentLimitMemory
+	Limit string
+	Value string
+}
+
+func (e ComponentLimitError) Error() string {
+	return fmt.Sprintf("component '%s' exceeded its %s of %s during '%s'", e.Component, e.Limit, e.Value, e.Step)
+}
+
+func NewComponentLimitError(componentName string, step MakeLifecycleStep, limit string, limits ComponentLimits) ComponentLimitError {
+	value := limits.Timeout.String()
+	if limit == ComponentLimitMemory {
+		value = units.BytesSize(float64(limits.MemoryLimit))
+	}
+	return ComponentLimitError{
+		Component: componentName,
+		Step:      step,
+		Limit:     limit,
+		Value:     value,
+	}
+}
+
+//ParseComponentTimeout accepts a duration like "1m30s" or a number of seconds
+func ParseComponentTimeout(str string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(str, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid timeout '"+str+"'")
+	}
+	return duration, nil
+}
+
+//ParseComponentMemoryLimit accepts a size like "512MiB" or "1g", or a number of bytes, of at least MinComponentMemoryLimit
+func ParseComponentMemoryLimit(str string) (uint64, error) {
+	size, err := units.RAMInBytes(str)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid memory limit '"+str+"'")
+	}
+	if size < 0 {
+		return 0, errors.New("invalid memory limit '" + str + "'")
+	}
+	if size < MinComponentMemoryLimit {
+		return 0, fmt.Errorf("memory limit '%s' is below the minimum of %s", str, units.BytesSize(MinComponentMemoryLimit))
+	}
+	return uint64(size), nil
+}
+
+func interpretComponentLimits(token SyntaxToken) (ComponentLimits, error) {
+	limits := ComponentLimits{}
+	attrs, err := extractBlockAttrs(token)
+	if err != nil {
+		return limits, err
+	}
+	for _, pair := range attrs {
+		switch pair.Key {
+		case ComponentLimitTimeout:
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return limits, errors.Wrap(err, "error parsing '"+ComponentLimitTimeout+"'")
+			}
+			limits.Timeout, err = ParseComponentTimeout(str)
+			if err != nil {
+				return limits, err
+			}
+		case ComponentLimitMemory:
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return limits, errors.Wrap(err, "error parsing '"+ComponentLimitMemory+"'")
+			}
+			limits.MemoryLimit, err = ParseComponentMemoryLimit(str)
+			if err != nil {
+				return limits, err
+			}
+		}
+	}
+	return limits, nil
+}
+
+//readComponentLimits must be called once the components are fetched, the overrides are keyed by component name
+//so a url written in a component_limits block matches the component fetched from it
+func (maker *Maker) readComponentLimits(container ConfigContainer) (ComponentLimits, map[string]ComponentLimits, error) {
+	defaults := ComponentLimits{}
+	overrides := map[string]ComponentLimits{}
+	resolvedUrls := maker.Fetcher.ResolvedUrls()
+	for name, group := range container.DataBags[ComponentLimitsDatabagType] {
+		for _, bag := range group {
+			limits, err := interpretComponentLimits(bag.Value)
+			if err != nil {
+				return defaults, nil, errors.Wrap(err, "error parsing '"+ComponentLimitsDatabagType+"' block '"+name+"'")
+			}
+			if name == "" {
+				defaults = limits.Or(defaults)
+				continue
+			}
+			componentName := name
+			if resolved, ok := resolvedUrls[name]; ok {
+				componentName = resolved
+			}
+			overrides[componentName] = limits.Or(overrides[componentName])
+		}
+	}
+	return defaults, overrides, nil
+}
+
+//ComponentLimitsFor returns the limits of the component named componentName (as in Executable.Components).
+//A component_limits block for the component takes precedence over the global limits (Maker.ComponentLimits),
+//which take precedence over the unnamed component_limits block
+func (maker *Maker) ComponentLimitsFor(componentName string) ComponentLimits {
+	return maker.Executable.Limits[componentName].Or(maker.ComponentLimits).Or(maker.Executable.DefaultLimits)
+}
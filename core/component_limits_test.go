@@ -0,0 +1,33 @@
+package core_test
+
+import (
+	"barbe/core"
+	"testing"
+)
+
+func TestParseComponentMemoryLimit(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected uint64
+	}{
+		{"512MiB", 512 * 1024 * 1024},
+		{"1g", 1024 * 1024 * 1024},
+		{"33554432", 32 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		limit, err := core.ParseComponentMemoryLimit(test.str)
+		if err != nil {
+			t.Errorf("error parsing '%s': %s", test.str, err)
+			continue
+		}
+		if limit != test.expected {
+			t.Errorf("'%s': expected %d, got %d", test.str, test.expected, limit)
+		}
+	}
+	for _, str := range []string{"", "lots", "-1", "0", "64KiB", "16MiB"} {
+		_, err := core.ParseComponentMemoryLimit(str)
+		if err == nil {
+			t.Errorf("expected '%s' to be refused", str)
+		}
+	}
+}
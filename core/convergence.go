@@ -0,0 +1,123 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+//convergenceWindow is how many of the last iterations are reported when the components don't converge
+const convergenceWindow = 3
+
+type DatabagChange struct {
+	Iteration int
+	//Id is type.name[labels]
+	Id string
+	//Diff is empty if the databag didn't exist before the iteration
+	Diff []string
+	//Producers are the components and transformers that contributed to the databag
+	Producers []string
+}
+
+//NonConvergenceError is returned when the components keep producing different databags after maxComponentLoops iterations
+type NonConvergenceError struct {
+	Step       MakeLifecycleStep
+	Iterations int
+	Changes    []DatabagChange
+}
+
+func (e NonConvergenceError) Error() string {
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("components did not converge during '%s' after %d iterations, databags changed in the last %d iterations:", e.Step, e.Iterations, convergenceWindow))
+	for _, change := range e.Changes {
+		b.WriteString(fmt.Sprintf("\n  iteration %d, %s", change.Iteration, change.Id))
+		if len(change.Diff) == 0 {
+			b.WriteString(" was created")
+		}
+		for _, line := range change.Diff {
+			b.WriteString("\n    ~ " + change.Id + line)
+		}
+		if len(change.Producers) != 0 {
+			b.WriteString("\n    produced by " + strings.Join(change.Producers, ", "))
+		}
+	}
+	return b.String()
+}
+
+//convergenceTracker keeps the changes of the last iterations of a component loop
+type convergenceTracker struct {
+	changes [][]DatabagChange
+}
+
+//record compares the databags of changed as they were in before and as they are in after
+func (t *convergenceTracker) record(iteration int, before ConfigContainer, after ConfigContainer, changed ConfigContainer) {
+	changes := make([]DatabagChange, 0)
+	for _, bagType := range sortedUnion(mapKeys(changed.DataBags), nil) {
+		for _, bagName := range sortedUnion(mapKeys(changed.DataBags[bagType]), nil) {
+			beforeByLabels := groupByLabels(before.GetDataBagGroup(bagType, bagName))
+			afterByLabels := groupByLabels(after.GetDataBagGroup(bagType, bagName))
+			changedByLabels := groupByLabels(changed.GetDataBagGroup(bagType, bagName))
+			for _, labels := range sortedUnion(mapKeys(changedByLabels), nil) {
+				afterBag, ok := afterByLabels[labels]
+				if !ok {
+					continue
+				}
+				change := DatabagChange{
+					Iteration: iteration,
+					Id:        databagId(bagType, bagName, labels),
+					Producers: producers(changedByLabels[labels].Provenance),
+				}
+				if beforeBag, ok := beforeByLabels[labels]; ok {
+					change.Diff = DiffTokens("", beforeBag.Value, afterBag.Value)
+					if len(change.Diff) == 0 {
+						continue
+					}
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+	t.changes = append(t.changes, changes)
+	if len(t.changes) > convergenceWindow {
+		t.changes = t.changes[len(t.changes)-convergenceWindow:]
+	}
+}
+
+func (t *convergenceTracker) error(step MakeLifecycleStep, iterations int) NonConvergenceError {
+	err := NonConvergenceError{
+		Step:       step,
+		Iterations: iterations,
+	}
+	for _, changes := range t.changes {
+		err.Changes = append(err.Changes, changes...)
+	}
+	return err
+}
+
+func producers(provenance []Provenance) []string {
+	output := make([]string, 0, len(provenance))
+	for _, p := range provenance {
+		if p.Kind == ProvenanceFile {
+			continue
+		}
+		output = append(output, p.Kind+" '"+p.Source+"'")
+	}
+	return sortedUnion(output, nil)
+}
+
+//databagsLike returns the databags of container with the same type and name as a databag of like
+func databagsLike(container ConfigContainer, like ConfigContainer) ConfigContainer {
+	output := NewConfigContainer()
+	for bagType, bags := range like.DataBags {
+		for bagName := range bags {
+			group := container.GetDataBagGroup(bagType, bagName)
+			if len(group) == 0 {
+				continue
+			}
+			if _, ok := output.DataBags[bagType]; !ok {
+				output.DataBags[bagType] = map[string]DataBagGroup{}
+			}
+			output.DataBags[bagType][bagName] = append(DataBagGroup{}, group...)
+		}
+	}
+	return *output
+}
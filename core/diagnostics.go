@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+type DiagnosticSeverity = string
+
+const (
+	DiagnosticSeverityError   = "error"
+	DiagnosticSeverityWarning = "warning"
+)
+
+type SourcePos struct {
+	Line   int
+	Column int
+	Byte   int
+}
+
+type SourceRange struct {
+	Filename string
+	Start    SourcePos
+	End      SourcePos
+}
+
+func (r SourceRange) String() string {
+	return fmt.Sprintf("%s:%d:%d", r.Filename, r.Start.Line, r.Start.Column)
+}
+
+//Diagnostic is a problem found in the input files, optionally located in one of them
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Summary  string
+	Detail   string       `json:",omitempty"`
+	Range    *SourceRange `json:",omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	str := d.Severity + ": " + d.Summary
+	if d.Detail != "" {
+		str += "; " + d.Detail
+	}
+	if d.Range != nil {
+		str = d.Range.String() + ": " + str
+	}
+	return str
+}
+
+//Diagnostics implements error so it can be returned through the usual error paths
+//and recovered with DiagnosticsFromError
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	strs := make([]string, 0, len(d))
+	for _, diag := range d {
+		strs = append(strs, diag.String())
+	}
+	return strings.Join(strs, "\n")
+}
+
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity == DiagnosticSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+//DiagnosticsFromError extracts the diagnostics wrapped in err, if there are none
+//the error is returned as a single diagnostic without location
+func DiagnosticsFromError(err error) Diagnostics {
+	if err == nil {
+		return nil
+	}
+	var diags Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+	return Diagnostics{
+		{
+			Severity: DiagnosticSeverityError,
+			Summary:  err.Error(),
+		},
+	}
+}
+
+//LocatedDiagnostics reports err at r, unless err already holds diagnostics, like the errors of the template parser
+func LocatedDiagnostics(r *SourceRange, summary string, err error) Diagnostics {
+	var diags Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+	return Diagnostics{
+		{
+			Severity: DiagnosticSeverityError,
+			Summary:  summary,
+			Detail:   err.Error(),
+			Range:    r,
+		},
+	}
+}
+
+//SourceFile locates positions in the content of a file, for the parsers that don't get them from their decoder
+type SourceFile struct {
+	Name       string
+	lineStarts []int
+}
+
+func NewSourceFile(name string, content []byte) SourceFile {
+	f := SourceFile{
+		Name:       name,
+		lineStarts: []int{0},
+	}
+	for i, c := range content {
+		if c == '\n' {
+			f.lineStarts = append(f.lineStarts, i+1)
+		}
+	}
+	return f
+}
+
+//Pos converts a byte offset to a 1-based line and column
+func (f SourceFile) Pos(offset int) SourcePos {
+	line := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	})
+	return SourcePos{
+		Line:   line,
+		Column: offset - f.lineStarts[line-1] + 1,
+		Byte:   offset,
+	}
+}
+
+func (f SourceFile) Range(start SourcePos, end SourcePos) *SourceRange {
+	return &SourceRange{
+		Filename: f.Name,
+		Start:    start,
+		End:      end,
+	}
+}
+
+//OffsetRange is Range between two byte offsets
+func (f SourceFile) OffsetRange(start int, end int) *SourceRange {
+	return f.Range(f.Pos(start), f.Pos(end))
+}
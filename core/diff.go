@@ -0,0 +1,145 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//DiffContainers returns a human readable line for each difference between the expected and actual databags,
+//an empty result means both containers are equal according to TokensDeepEqual
+func DiffContainers(expected ConfigContainer, actual ConfigContainer) []string {
+	output := make([]string, 0)
+	for _, bagType := range sortedUnion(mapKeys(expected.DataBags), mapKeys(actual.DataBags)) {
+		for _, bagName := range sortedUnion(mapKeys(expected.DataBags[bagType]), mapKeys(actual.DataBags[bagType])) {
+			expectedByLabels := groupByLabels(expected.DataBags[bagType][bagName])
+			actualByLabels := groupByLabels(actual.DataBags[bagType][bagName])
+			for _, labels := range sortedUnion(mapKeys(expectedByLabels), mapKeys(actualByLabels)) {
+				bagId := databagId(bagType, bagName, labels)
+				expectedBag, inExpected := expectedByLabels[labels]
+				actualBag, inActual := actualByLabels[labels]
+				switch {
+				case !inActual:
+					output = append(output, "- "+bagId+" is missing")
+				case !inExpected:
+					output = append(output, "+ "+bagId+" was not expected")
+				default:
+					for _, line := range DiffTokens("", expectedBag.Value, actualBag.Value) {
+						output = append(output, "~ "+bagId+line)
+					}
+				}
+			}
+		}
+	}
+	return output
+}
+
+//DiffTokens returns a line for each difference between the 2 tokens, prefixed by the path of the difference
+func DiffTokens(path string, expected SyntaxToken, actual SyntaxToken) []string {
+	if expected.Type == actual.Type {
+		switch expected.Type {
+		case TokenTypeObjectConst:
+			return diffObjects(path, expected, actual)
+		case TokenTypeArrayConst:
+			if len(expected.ArrayConst) == len(actual.ArrayConst) {
+				output := make([]string, 0)
+				for i := range expected.ArrayConst {
+					output = append(output, DiffTokens(fmt.Sprintf("%s[%d]", path, i), expected.ArrayConst[i], actual.ArrayConst[i])...)
+				}
+				return output
+			}
+		}
+	}
+	if TokensDeepEqual(expected, actual) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: expected %s, got %s", path, tokenSummary(expected), tokenSummary(actual))}
+}
+
+func diffObjects(path string, expected SyntaxToken, actual SyntaxToken) []string {
+	//objects can contain the same key several times, on purpose
+	expectedValues := map[string][]SyntaxToken{}
+	for _, pair := range expected.ObjectConst {
+		expectedValues[pair.Key] = append(expectedValues[pair.Key], pair.Value)
+	}
+	actualValues := map[string][]SyntaxToken{}
+	for _, pair := range actual.ObjectConst {
+		actualValues[pair.Key] = append(actualValues[pair.Key], pair.Value)
+	}
+	output := make([]string, 0)
+	for _, key := range sortedUnion(mapKeys(expectedValues), mapKeys(actualValues)) {
+		keyPath := path + "." + key
+		expectedItems, inExpected := expectedValues[key]
+		actualItems, inActual := actualValues[key]
+		switch {
+		case !inActual:
+			output = append(output, keyPath+": missing")
+		case !inExpected:
+			output = append(output, keyPath+": was not expected, got "+tokenSummary(actualItems[0]))
+		case len(expectedItems) != len(actualItems):
+			output = append(output, fmt.Sprintf("%s: expected the key %d time(s), got it %d time(s)", keyPath, len(expectedItems), len(actualItems)))
+		case len(expectedItems) == 1:
+			output = append(output, DiffTokens(keyPath, expectedItems[0], actualItems[0])...)
+		default:
+			for i := range expectedItems {
+				output = append(output, DiffTokens(fmt.Sprintf("%s(%d)", keyPath, i), expectedItems[i], actualItems[i])...)
+			}
+		}
+	}
+	return output
+}
+
+func tokenSummary(token SyntaxToken) string {
+	if redacted, err := RedactSensitive(token); err == nil {
+		token = redacted
+	}
+	if token.Type == TokenTypeLiteralValue {
+		b, err := json.Marshal(token.Value)
+		if err == nil {
+			return string(b)
+		}
+	}
+	b, err := json.Marshal(token)
+	if err != nil {
+		return token.Type
+	}
+	return string(b)
+}
+
+func databagId(bagType string, bagName string, labels string) string {
+	id := bagType + "." + bagName
+	if labels != "" {
+		id += "[" + labels + "]"
+	}
+	return id
+}
+
+func groupByLabels(group DataBagGroup) map[string]DataBag {
+	output := make(map[string]DataBag, len(group))
+	for _, bag := range group {
+		output[strings.Join(bag.Labels, ".")] = bag
+	}
+	return output
+}
+
+func mapKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func sortedUnion(a []string, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, s := range a {
+		set[s] = struct{}{}
+	}
+	for _, s := range b {
+		set[s] = struct{}{}
+	}
+	output := mapKeys(set)
+	sort.Strings(output)
+	return output
+}
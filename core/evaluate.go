@@ -0,0 +1,552 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/tryfunc"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+	"strings"
+)
+
+//EvaluateExpression computes the value of an expression that only references the given variables (as var.<name>).
+//The expression is evaluated by HCL with the functions of evalFunctions, so it behaves like in Terraform.
+//The value is made of string, float64, bool, nil, []interface{} and map[string]interface{}
+func EvaluateExpression(token SyntaxToken, variables map[string]interface{}) (interface{}, error) {
+	return EvaluateExpressionInScope(token, map[string]interface{}{"var": variables})
+}
+
+//EvaluateExpressionInScope is EvaluateExpression where the traversals are looked up in scope,
+//ex: `env.STAGE` is scope["env"]["STAGE"]
+func EvaluateExpressionInScope(token SyntaxToken, scope map[string]interface{}) (interface{}, error) {
+	expr, err := tokenToHclExpression(token, nil)
+	if err != nil {
+		return nil, err
+	}
+	evalCtx := &hcl.EvalContext{
+		Variables: make(map[string]cty.Value, len(scope)),
+		Functions: evalFunctions,
+	}
+	for name, v := range scope {
+		evalCtx.Variables[name], err = goValueToCty(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "error converting '"+name+"'")
+		}
+	}
+	value, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		strs := make([]string, 0, len(diags))
+		for _, diag := range diags {
+			if diag.Severity != hcl.DiagError {
+				continue
+			}
+			strs = append(strs, strings.TrimSuffix(diag.Summary+"; "+diag.Detail, "; "))
+		}
+		return nil, errors.New(strings.Join(strs, "\n"))
+	}
+	return ctyToGoValue(value)
+}
+
+//evalFunctions are the cty standard library under their Terraform names, with the few Terraform functions
+//that aren't in it
+var evalFunctions = map[string]function.Function{
+	"abs":             stdlib.AbsoluteFunc,
+	"alltrue":         allBoolsFunc(true),
+	"anytrue":         allBoolsFunc(false),
+	"can":             tryfunc.CanFunc,
+	"ceil":            stdlib.CeilFunc,
+	"chomp":           stdlib.ChompFunc,
+	"chunklist":       stdlib.ChunklistFunc,
+	"coalesce":        stdlib.CoalesceFunc,
+	"coalescelist":    stdlib.CoalesceListFunc,
+	"compact":         stdlib.CompactFunc,
+	"concat":          stdlib.ConcatFunc,
+	"contains":        stdlib.ContainsFunc,
+	"distinct":        stdlib.DistinctFunc,
+	"element":         stdlib.ElementFunc,
+	"endswith":        affixFunc(strings.HasSuffix),
+	"flatten":         stdlib.FlattenFunc,
+	"floor":           stdlib.FloorFunc,
+	"format":          stdlib.FormatFunc,
+	"formatlist":      stdlib.FormatListFunc,
+	"indent":          stdlib.IndentFunc,
+	"join":            stdlib.JoinFunc,
+	"jsondecode":      stdlib.JSONDecodeFunc,
+	"jsonencode":      stdlib.JSONEncodeFunc,
+	"keys":            stdlib.KeysFunc,
+	"length":          lengthFunc,
+	"log":             stdlib.LogFunc,
+	"lookup":          stdlib.LookupFunc,
+	"lower":           stdlib.LowerFunc,
+	"max":             stdlib.MaxFunc,
+	"merge":           stdlib.MergeFunc,
+	"min":             stdlib.MinFunc,
+	"parseint":        stdlib.ParseIntFunc,
+	"pow":             stdlib.PowFunc,
+	"range":           stdlib.RangeFunc,
+	"regex":           stdlib.RegexFunc,
+	"regexall":        stdlib.RegexAllFunc,
+	"replace":         stdlib.ReplaceFunc,
+	"reverse":         stdlib.ReverseListFunc,
+	"setintersection": stdlib.SetIntersectionFunc,
+	"setsubtract":     stdlib.SetSubtractFunc,
+	"setunion":        stdlib.SetUnionFunc,
+	"signum":          stdlib.SignumFunc,
+	"slice":           stdlib.SliceFunc,
+	"sort":            stdlib.SortFunc,
+	"split":           stdlib.SplitFunc,
+	"startswith":      affixFunc(strings.HasPrefix),
+	"strlen":          stdlib.StrlenFunc,
+	"strrev":          stdlib.ReverseFunc,
+	"substr":          stdlib.SubstrFunc,
+	"title":           stdlib.TitleFunc,
+	"trim":            stdlib.TrimFunc,
+	"trimprefix":      stdlib.TrimPrefixFunc,
+	"trimspace":       stdlib.TrimSpaceFunc,
+	"trimsuffix":      stdlib.TrimSuffixFunc,
+	"try":             tryfunc.TryFunc,
+	"upper":           stdlib.UpperFunc,
+	"values":          stdlib.ValuesFunc,
+	"zipmap":          stdlib.ZipmapFunc,
+}
+
+//lengthFunc is Terraform's length, which also counts the characters of a string
+var lengthFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:             "value",
+			Type:             cty.DynamicPseudoType,
+			AllowDynamicType: true,
+			AllowUnknown:     true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		if args[0].Type() == cty.String {
+			return stdlib.Strlen(args[0])
+		}
+		return stdlib.Length(args[0])
+	},
+})
+
+//affixFunc is Terraform's startswith or endswith
+func affixFunc(has func(s string, affix string) bool) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "str", Type: cty.String},
+			{Name: "affix", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.Bool),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.BoolVal(has(args[0].AsString(), args[1].AsString())), nil
+		},
+	})
+}
+
+//allBoolsFunc is Terraform's alltrue if all is true and anytrue otherwise
+func allBoolsFunc(all bool) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "list", Type: cty.List(cty.Bool)},
+		},
+		Type: function.StaticReturnType(cty.Bool),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			for it := args[0].ElementIterator(); it.Next(); {
+				_, v := it.Element()
+				if v.IsNull() {
+					return cty.False, errors.New("the list can't contain null values")
+				}
+				if v.True() != all {
+					return cty.BoolVal(!all), nil
+				}
+			}
+			return cty.BoolVal(all), nil
+		},
+	})
+}
+
+//tokenToHclExpression builds the HCL expression the token was parsed from, splatItem is
+//what the anonymous tokens refer to inside the Each of a splat
+func tokenToHclExpression(token SyntaxToken, splatItem *hclsyntax.AnonSymbolExpr) (hclsyntax.Expression, error) {
+	convert := func(t *SyntaxToken) (hclsyntax.Expression, error) {
+		if t == nil {
+			return nil, fmt.Errorf("incomplete '%s' expression", token.Type)
+		}
+		return tokenToHclExpression(*t, splatItem)
+	}
+	convertAll := func(tokens []SyntaxToken) ([]hclsyntax.Expression, error) {
+		exprs := make([]hclsyntax.Expression, 0, len(tokens))
+		for _, t := range tokens {
+			expr, err := tokenToHclExpression(t, splatItem)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+		}
+		return exprs, nil
+	}
+
+	switch token.Type {
+	default:
+		return nil, fmt.Errorf("'%s' expressions are not supported here", token.Type)
+
+	case TokenTypeLiteralValue:
+		value, err := goValueToCty(token.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.LiteralValueExpr{Val: value}, nil
+
+	case TokenTypeTemplate:
+		parts, err := convertAll(token.Parts)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.TemplateExpr{Parts: parts}, nil
+
+	case TokenTypeArrayConst:
+		items, err := convertAll(token.ArrayConst)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.TupleConsExpr{Exprs: items}, nil
+
+	case TokenTypeObjectConst:
+		items := make([]hclsyntax.ObjectConsItem, 0, len(token.ObjectConst))
+		for _, pair := range token.ObjectConst {
+			value, err := tokenToHclExpression(pair.Value, splatItem)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, hclsyntax.ObjectConsItem{
+				KeyExpr:   &hclsyntax.LiteralValueExpr{Val: cty.StringVal(pair.Key)},
+				ValueExpr: value,
+			})
+		}
+		return &hclsyntax.ObjectConsExpr{Items: items}, nil
+
+	case TokenTypeParens:
+		source, err := convert(token.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.ParenthesesExpr{Expression: source}, nil
+
+	case TokenTypeScopeTraversal:
+		if len(token.Traversal) == 0 || token.Traversal[0].Name == nil {
+			return nil, errors.New("a traversal must start with a name")
+		}
+		traversal, err := traversalToHcl(token.Traversal[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.ScopeTraversalExpr{
+			Traversal: append(hcl.Traversal{hcl.TraverseRoot{Name: *token.Traversal[0].Name}}, traversal...),
+		}, nil
+
+	case TokenTypeRelativeTraversal:
+		source, err := convert(token.Source)
+		if err != nil {
+			return nil, err
+		}
+		traversal, err := traversalToHcl(token.Traversal)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.RelativeTraversalExpr{Source: source, Traversal: traversal}, nil
+
+	case TokenTypeIndexAccess:
+		collection, err := convert(token.IndexCollection)
+		if err != nil {
+			return nil, err
+		}
+		key, err := convert(token.IndexKey)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.IndexExpr{Collection: collection, Key: key}, nil
+
+	case TokenTypeConditional:
+		condition, err := convert(token.Condition)
+		if err != nil {
+			return nil, err
+		}
+		trueResult, err := convert(token.TrueResult)
+		if err != nil {
+			return nil, err
+		}
+		falseResult, err := convert(token.FalseResult)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.ConditionalExpr{Condition: condition, TrueResult: trueResult, FalseResult: falseResult}, nil
+
+	case TokenTypeUnaryOp:
+		if token.Operator == nil {
+			return nil, errors.New("unary operation without operator")
+		}
+		value, err := convert(token.RightHandSide)
+		if err != nil {
+			return nil, err
+		}
+		switch *token.Operator {
+		case "!":
+			return &hclsyntax.UnaryOpExpr{Op: hclsyntax.OpLogicalNot, Val: value}, nil
+		case "-":
+			return &hclsyntax.UnaryOpExpr{Op: hclsyntax.OpNegate, Val: value}, nil
+		}
+		return nil, fmt.Errorf("unknown operator '%s'", *token.Operator)
+
+	case TokenTypeBinaryOp:
+		if token.Operator == nil {
+			return nil, errors.New("binary operation without operator")
+		}
+		op, ok := binaryOperations[*token.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unknown operator '%s'", *token.Operator)
+		}
+		left, err := convert(token.LeftHandSide)
+		if err != nil {
+			return nil, err
+		}
+		right, err := convert(token.RightHandSide)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.BinaryOpExpr{LHS: left, Op: op, RHS: right}, nil
+
+	case TokenTypeFunctionCall:
+		if token.FunctionName == nil {
+			return nil, errors.New("function call without name")
+		}
+		args, err := convertAll(token.FunctionArgs)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.FunctionCallExpr{Name: *token.FunctionName, Args: args}, nil
+
+	case TokenTypeFor:
+		if token.ForValVar == nil {
+			return nil, errors.New("for expression without value variable")
+		}
+		expr := &hclsyntax.ForExpr{ValVar: *token.ForValVar}
+		if token.ForKeyVar != nil {
+			expr.KeyVar = *token.ForKeyVar
+		}
+		var err error
+		expr.CollExpr, err = convert(token.ForCollExpr)
+		if err != nil {
+			return nil, err
+		}
+		expr.ValExpr, err = convert(token.ForValExpr)
+		if err != nil {
+			return nil, err
+		}
+		if token.ForKeyExpr != nil {
+			expr.KeyExpr, err = convert(token.ForKeyExpr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if token.ForCondExpr != nil {
+			expr.CondExpr, err = convert(token.ForCondExpr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expr, nil
+
+	case TokenTypeSplat:
+		source, err := convert(token.Source)
+		if err != nil {
+			return nil, err
+		}
+		if token.SplatEach == nil {
+			return nil, errors.New("splat expression without each")
+		}
+		item := &hclsyntax.AnonSymbolExpr{}
+		each, err := tokenToHclExpression(*token.SplatEach, item)
+		if err != nil {
+			return nil, err
+		}
+		return &hclsyntax.SplatExpr{Source: source, Each: each, Item: item}, nil
+
+	case TokenTypeAnonymous:
+		if splatItem == nil {
+			return nil, errors.New("anonymous symbol outside of a splat expression")
+		}
+		return splatItem, nil
+	}
+}
+
+var binaryOperations = map[string]*hclsyntax.Operation{
+	"==": hclsyntax.OpEqual,
+	"!=": hclsyntax.OpNotEqual,
+	"||": hclsyntax.OpLogicalOr,
+	"&&": hclsyntax.OpLogicalAnd,
+	">":  hclsyntax.OpGreaterThan,
+	">=": hclsyntax.OpGreaterThanOrEqual,
+	"<":  hclsyntax.OpLessThan,
+	"<=": hclsyntax.OpLessThanOrEqual,
+	"+":  hclsyntax.OpAdd,
+	"-":  hclsyntax.OpSubtract,
+	"*":  hclsyntax.OpMultiply,
+	"/":  hclsyntax.OpDivide,
+	"%":  hclsyntax.OpModulo,
+}
+
+func traversalToHcl(traversal []Traverse) (hcl.Traversal, error) {
+	output := make(hcl.Traversal, 0, len(traversal))
+	for _, traverse := range traversal {
+		if traverse.Type == TraverseTypeAttr {
+			if traverse.Name == nil {
+				return nil, errors.New("attribute traversal without name")
+			}
+			output = append(output, hcl.TraverseAttr{Name: *traverse.Name})
+			continue
+		}
+		key, err := goValueToCty(traverse.Index)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, hcl.TraverseIndex{Key: key})
+	}
+	return output, nil
+}
+
+//goValueToCty converts the values returned by TokenToGoValue, numbers can be of any Go type
+func goValueToCty(v interface{}) (cty.Value, error) {
+	switch value := normalizeValue(v).(type) {
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	case string:
+		return cty.StringVal(value), nil
+	case bool:
+		return cty.BoolVal(value), nil
+	case float64:
+		return cty.NumberFloatVal(value), nil
+	case []interface{}:
+		items := make([]cty.Value, 0, len(value))
+		for _, item := range value {
+			ctyItem, err := goValueToCty(item)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			items = append(items, ctyItem)
+		}
+		return cty.TupleVal(items), nil
+	case map[string]interface{}:
+		attrs := make(map[string]cty.Value, len(value))
+		for k, item := range value {
+			ctyItem, err := goValueToCty(item)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			attrs[k] = ctyItem
+		}
+		return cty.ObjectVal(attrs), nil
+	}
+	return cty.NilVal, fmt.Errorf("unsupported value of type %T", v)
+}
+
+func ctyToGoValue(v cty.Value) (interface{}, error) {
+	if !v.IsKnown() {
+		return nil, errors.New("the value is not known")
+	}
+	v, _ = v.Unmark()
+	if v.IsNull() {
+		return nil, nil
+	}
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return v.AsString(), nil
+	case t == cty.Bool:
+		return v.True(), nil
+	case t == cty.Number:
+		f, _ := v.AsBigFloat().Float64()
+		return f, nil
+	case t.IsListType() || t.IsTupleType() || t.IsSetType():
+		output := make([]interface{}, 0, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			_, item := it.Element()
+			goItem, err := ctyToGoValue(item)
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, goItem)
+		}
+		return output, nil
+	case t.IsMapType() || t.IsObjectType():
+		output := make(map[string]interface{}, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			key, item := it.Element()
+			goItem, err := ctyToGoValue(item)
+			if err != nil {
+				return nil, err
+			}
+			output[key.AsString()] = goItem
+		}
+		return output, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", t.FriendlyName())
+}
+
+//normalizeValue turns the numbers into float64, recursively
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case json.Number:
+		f, err := value.Float64()
+		if err != nil {
+			return value.String()
+		}
+		return f
+	case []interface{}:
+		output := make([]interface{}, len(value))
+		for i, item := range value {
+			output[i] = normalizeValue(item)
+		}
+		return output
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			output[k] = normalizeValue(item)
+		}
+		return output
+	}
+	return v
+}
+
+func describeValue(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a bool"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
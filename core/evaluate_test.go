@@ -0,0 +1,86 @@
+package core_test
+
+import (
+	"barbe/core"
+	"barbe/core/hcl_parser"
+	"reflect"
+	"testing"
+)
+
+func evaluate(t *testing.T, expr string, variables map[string]interface{}) (interface{}, error) {
+	t.Helper()
+	token, err := hcl_parser.ParseTemplateString("${"+expr+"}", "test.hcl", core.SourcePos{Line: 1, Column: 1})
+	if err != nil {
+		t.Fatalf("error parsing '%s': %s", expr, err)
+	}
+	return core.EvaluateExpression(token, variables)
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	variables := map[string]interface{}{
+		"stage":   "prod",
+		"name":    "api-server",
+		"count":   3,
+		"tags":    map[string]interface{}{"team": "api"},
+		"subnets": []interface{}{"a", "b"},
+		"flags":   []interface{}{true, false},
+	}
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{`var.stage == "prod"`, true},
+		{`var.count * 2 + 1`, float64(7)},
+		{`var.count % 2 == 1 && !(var.count > 5)`, true},
+		{`-var.count`, float64(-3)},
+		{`var.count == 3.0`, true},
+		{`var.stage == "prod" ? "p" : "other"`, "p"},
+		{`"${var.stage}-${var.count}"`, "prod-3"},
+		{`var.tags.team`, "api"},
+		{`var.tags["team"]`, "api"},
+		{`var.subnets[1]`, "b"},
+		{`var.subnets`, []interface{}{"a", "b"}},
+		{`{a = var.count}`, map[string]interface{}{"a": float64(3)}},
+		{`[for s in var.subnets : upper(s)]`, []interface{}{"A", "B"}},
+		{`contains(["dev", "staging", "prod"], var.stage)`, true},
+		{`length(var.name)`, float64(10)},
+		{`length(var.subnets)`, float64(2)},
+		{`length(var.name) <= 32 && can(regex("^[a-z-]+$", var.name))`, true},
+		{`can(regex("^[0-9]+$", var.name))`, false},
+		{`lookup(var.tags, "team", "none")`, "api"},
+		{`lookup(var.tags, "owner", "none")`, "none"},
+		{`startswith(var.name, "api")`, true},
+		{`endswith(var.name, "api")`, false},
+		{`alltrue(var.flags)`, false},
+		{`anytrue(var.flags)`, true},
+		{`try(var.tags.owner, "nobody")`, "nobody"},
+	}
+	for _, test := range tests {
+		value, err := evaluate(t, test.expr, variables)
+		if err != nil {
+			t.Errorf("error evaluating '%s': %s", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("'%s': expected %#v, got %#v", test.expr, test.expected, value)
+		}
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	variables := map[string]interface{}{
+		"stage": "prod",
+	}
+	for _, expr := range []string{
+		`var.unknown`,
+		`local.stage`,
+		`var.stage + 1`,
+		`nofunction(var.stage)`,
+		`var.stage[0]`,
+	} {
+		_, err := evaluate(t, expr, variables)
+		if err == nil {
+			t.Errorf("expected '%s' to fail", expr)
+		}
+	}
+}
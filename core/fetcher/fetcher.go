@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -33,42 +34,191 @@ type UrlTransformer func(string) string
 
 //Fetches urls and cache their contents, will eventually also handle auth
 type Fetcher struct {
-	mutex          *sync.RWMutex
-	fileCache      map[string]FileDescription
+	mutex     *sync.RWMutex
+	fileCache map[string]FileDescription
+	//url (after transformation) -> path on disk, for the files fetched from the local filesystem
+	localFiles map[string]string
+	//requested url -> url the file was fetched from (or vendored from)
+	resolvedUrls map[string]string
+	//path of a vendored file -> url it was vendored from
+	vendoredUrls map[string]string
+	//requested url -> file returned for it
+	fetched        map[string]FileDescription
 	UrlTransformer []UrlTransformer
+	//BaseDir is the directory relative paths are read from, the working directory of the process if empty
+	BaseDir string
+	//offline makes the files that are not already cached fail to fetch
+	offline bool
+
+	lockMutex   sync.Mutex
+	lock        *Lock
+	updateLock  bool
+	lockChanged bool
 }
 
 func NewFetcher() *Fetcher {
 	return &Fetcher{
-		mutex:     &sync.RWMutex{},
-		fileCache: map[string]FileDescription{},
+		mutex:        &sync.RWMutex{},
+		fileCache:    map[string]FileDescription{},
+		localFiles:   map[string]string{},
+		resolvedUrls: map[string]string{},
+		vendoredUrls: map[string]string{},
+		fetched:      map[string]FileDescription{},
 	}
 }
 
-func (fetcher *Fetcher) Fetch(url string) (FileDescription, error) {
+func (fetcher *Fetcher) Fetch(requestedUrl string) (FileDescription, error) {
+	url := requestedUrl
 	for _, transformer := range fetcher.UrlTransformer {
 		url = transformer(url)
 	}
+	url = fetcher.resolveRelativePath(url)
 	fetcher.mutex.RLock()
 	if cached, ok := fetcher.fileCache[url]; ok {
 		fetcher.mutex.RUnlock()
+		//the same file can be requested through different urls, each of them is locked
+		err := fetcher.checkLock(requestedUrl, cached.Name, cached.Content)
+		if err != nil {
+			return FileDescription{}, err
+		}
+		fetcher.mutex.Lock()
+		fetcher.resolvedUrls[requestedUrl] = cached.Name
+		fetcher.fetched[requestedUrl] = cached
+		fetcher.mutex.Unlock()
 		return cached, nil
 	}
+	offline := fetcher.offline
 	fetcher.mutex.RUnlock()
+	if offline {
+		return FileDescription{}, errors.New("'" + url + "' is not available offline")
+	}
 	content, err := FetchFile(url)
 	if err != nil {
 		return FileDescription{}, errors.Wrap(err, "error fetching file at '"+url+"'")
 	}
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+	name := url
+	if vendoredUrl, ok := fetcher.vendoredUrls[url]; ok {
+		name = vendoredUrl
+	}
+	err = fetcher.checkLock(requestedUrl, name, content)
+	if err != nil {
+		return FileDescription{}, err
+	}
 	file := FileDescription{
-		Name:    url,
+		Name:    name,
 		Content: content,
 	}
-	fetcher.mutex.Lock()
-	defer fetcher.mutex.Unlock()
 	fetcher.fileCache[url] = file
+	fetcher.resolvedUrls[requestedUrl] = name
+	fetcher.fetched[requestedUrl] = file
+	if localPath, ok := localFilePath(url); ok {
+		fetcher.localFiles[url] = localPath
+	}
 	return file, nil
 }
 
+//ResolvedUrls returns the url each fetched file was requested with, mapped to the url it was fetched from
+func (fetcher *Fetcher) ResolvedUrls() map[string]string {
+	fetcher.mutex.RLock()
+	defer fetcher.mutex.RUnlock()
+	output := make(map[string]string, len(fetcher.resolvedUrls))
+	for requestedUrl, url := range fetcher.resolvedUrls {
+		output[requestedUrl] = url
+	}
+	return output
+}
+
+//FetchedFiles returns the files fetched so far, keyed by the url they were requested with
+func (fetcher *Fetcher) FetchedFiles() map[string]FileDescription {
+	fetcher.mutex.RLock()
+	defer fetcher.mutex.RUnlock()
+	output := make(map[string]FileDescription, len(fetcher.fetched))
+	for requestedUrl, file := range fetcher.fetched {
+		output[requestedUrl] = file
+	}
+	return output
+}
+
+//UseFetchedFiles makes the fetcher return the given files (as returned by FetchedFiles) and nothing else.
+//Relative urls are resolved against BaseDir, it must be set before
+func (fetcher *Fetcher) UseFetchedFiles(files map[string]FileDescription) {
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+	for requestedUrl, file := range files {
+		fetcher.fileCache[requestedUrl] = file
+		fetcher.fileCache[fetcher.resolveRelativePath(requestedUrl)] = file
+	}
+	fetcher.offline = true
+}
+
+//LocalFiles returns the paths of all the files that were fetched from the local filesystem
+func (fetcher *Fetcher) LocalFiles() []string {
+	fetcher.mutex.RLock()
+	defer fetcher.mutex.RUnlock()
+	output := make([]string, 0, len(fetcher.localFiles))
+	for _, localPath := range fetcher.localFiles {
+		output = append(output, localPath)
+	}
+	return output
+}
+
+//InvalidateLocalFiles removes the local files from the cache so they are read again on the next fetch,
+//remote files stay cached
+func (fetcher *Fetcher) InvalidateLocalFiles() {
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+	for url := range fetcher.localFiles {
+		delete(fetcher.fileCache, url)
+	}
+	fetcher.localFiles = map[string]string{}
+}
+
+//resolveRelativePath joins relative paths to BaseDir, barbe hub identifiers are left as is unless a file with that name exists in BaseDir
+func (fetcher *Fetcher) resolveRelativePath(url string) string {
+	if fetcher.BaseDir == "" {
+		return url
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "base64://") {
+		return url
+	}
+	prefix := ""
+	if strings.HasPrefix(url, "file://") {
+		prefix = "file://"
+	}
+	filePath := strings.TrimPrefix(url, prefix)
+	if filepath.IsAbs(filePath) {
+		return url
+	}
+	joined := filepath.Join(fetcher.BaseDir, filePath)
+	if _, _, _, _, err := ParseBarbeHubIdentifier(filePath); err == nil && prefix == "" {
+		if _, err := os.Stat(joined); err != nil {
+			return url
+		}
+	}
+	return prefix + joined
+}
+
+//IsLocalUrl is true if url points to a file on the local filesystem
+func IsLocalUrl(url string) bool {
+	_, ok := localFilePath(url)
+	return ok
+}
+
+func localFilePath(fileUrl string) (string, bool) {
+	if strings.HasPrefix(fileUrl, "file://") {
+		return strings.TrimPrefix(fileUrl, "file://"), true
+	}
+	if strings.HasPrefix(fileUrl, "http://") || strings.HasPrefix(fileUrl, "https://") || strings.HasPrefix(fileUrl, "base64://") {
+		return "", false
+	}
+	if _, err := os.Stat(fileUrl); !os.IsNotExist(err) {
+		return fileUrl, true
+	}
+	return "", false
+}
+
 // anyfront/manifest.json:v0.2.1
 var BarbeHubRegex = regexp.MustCompile(`^(?P<owner>[a-zA-Z0-9-_*]+)/(?P<comp>[a-zA-Z0-9-_*]+)\.(?P<ext>[a-zA-Z0-9.*]+):?(?P<tag>[a-zA-Z0-9.*]+)?$`)
 
@@ -0,0 +1,129 @@
+package fetcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+const (
+	LockFileName             = "barbe.lock"
+	CurrentLockFormatVersion = 1
+)
+
+type LockEntry struct {
+	//ResolvedUrl is the url after the fetcher's UrlTransformer were applied
+	ResolvedUrl string
+	Sha256      string
+}
+
+//Lock pins the content of every remote file fetched, keyed by the url as it was requested (ex: a hub identifier)
+type Lock struct {
+	FormatVersion int
+	Entries       map[string]LockEntry
+}
+
+func NewLock() *Lock {
+	return &Lock{
+		FormatVersion: CurrentLockFormatVersion,
+		Entries:       map[string]LockEntry{},
+	}
+}
+
+//ReadLock returns nil if there is no lock file at lockPath
+func ReadLock(lockPath string) (*Lock, error) {
+	b, err := os.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading lock file at '"+lockPath+"'")
+	}
+	lock := NewLock()
+	err = json.Unmarshal(b, lock)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing lock file at '"+lockPath+"'")
+	}
+	if lock.FormatVersion != CurrentLockFormatVersion {
+		return nil, fmt.Errorf("unsupported lock file format version %d at '%s'", lock.FormatVersion, lockPath)
+	}
+	if lock.Entries == nil {
+		lock.Entries = map[string]LockEntry{}
+	}
+	return lock, nil
+}
+
+func (l *Lock) Write(lockPath string) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshalling lock file")
+	}
+	err = os.WriteFile(lockPath, append(b, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing lock file at '"+lockPath+"'")
+	}
+	return nil
+}
+
+func ContentSha256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+//SetLock makes the fetcher verify the content of remote files against lock, nil disables the verification.
+//If update is true, content that doesn't match the lock replaces the lock's entry instead of being refused
+func (fetcher *Fetcher) SetLock(lock *Lock, update bool) {
+	fetcher.lockMutex.Lock()
+	defer fetcher.lockMutex.Unlock()
+	fetcher.lock = lock
+	fetcher.updateLock = update
+	fetcher.lockChanged = false
+}
+
+//checkLock verifies the content fetched for url against the lock. Urls the lock doesn't know about are refused,
+//unless the lock is being updated in which case they are recorded.
+//Local files (including components found through BARBE_LOCAL) are never locked
+func (fetcher *Fetcher) checkLock(url string, resolvedUrl string, content []byte) error {
+	if _, isLocal := localFilePath(resolvedUrl); isLocal || strings.HasPrefix(resolvedUrl, "base64://") {
+		return nil
+	}
+	fetcher.lockMutex.Lock()
+	defer fetcher.lockMutex.Unlock()
+	if fetcher.lock == nil {
+		return nil
+	}
+	sha := ContentSha256(content)
+	entry, ok := fetcher.lock.Entries[url]
+	if !ok && !fetcher.updateLock {
+		return fmt.Errorf("'%s' is not in %s, run `barbe lock` or run with --update-lock to add it", url, LockFileName)
+	}
+	if ok && !fetcher.updateLock {
+		if entry.ResolvedUrl != resolvedUrl {
+			return fmt.Errorf("'%s' resolved to '%s' but %s expects '%s', run with --update-lock to accept the change", url, resolvedUrl, LockFileName, entry.ResolvedUrl)
+		}
+		if entry.Sha256 != sha {
+			return fmt.Errorf("content of '%s' doesn't match %s (expected sha256 %s, got %s), run with --update-lock to accept the change", url, LockFileName, entry.Sha256, sha)
+		}
+		return nil
+	}
+	newEntry := LockEntry{
+		ResolvedUrl: resolvedUrl,
+		Sha256:      sha,
+	}
+	if entry != newEntry {
+		fetcher.lock.Entries[url] = newEntry
+		fetcher.lockChanged = true
+	}
+	return nil
+}
+
+//LockChanged is true if entries were added or updated in the lock since it was set
+func (fetcher *Fetcher) LockChanged() bool {
+	fetcher.lockMutex.Lock()
+	defer fetcher.lockMutex.Unlock()
+	return fetcher.lockChanged
+}
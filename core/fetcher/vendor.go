@@ -0,0 +1,119 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	VendorIndexFileName             = "index.json"
+	CurrentVendorIndexFormatVersion = 1
+)
+
+type VendorEntry struct {
+	//Path is relative to the vendor directory
+	Path string
+	//ResolvedUrl is the url the file was fetched from, vendored files keep it as their name
+	ResolvedUrl string
+}
+
+//VendorIndex maps the url of each vendored file, as it was requested (ex: a hub identifier), to where it is stored
+type VendorIndex struct {
+	FormatVersion int
+	Files         map[string]VendorEntry
+}
+
+func NewVendorIndex() *VendorIndex {
+	return &VendorIndex{
+		FormatVersion: CurrentVendorIndexFormatVersion,
+		Files:         map[string]VendorEntry{},
+	}
+}
+
+//ReadVendorIndex returns nil if vendorDir has no index
+func ReadVendorIndex(vendorDir string) (*VendorIndex, error) {
+	indexPath := path.Join(vendorDir, VendorIndexFileName)
+	b, err := os.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading vendor index at '"+indexPath+"'")
+	}
+	index := NewVendorIndex()
+	err = json.Unmarshal(b, index)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing vendor index at '"+indexPath+"'")
+	}
+	if index.FormatVersion != CurrentVendorIndexFormatVersion {
+		return nil, fmt.Errorf("unsupported vendor index format version %d at '%s'", index.FormatVersion, indexPath)
+	}
+	if index.Files == nil {
+		index.Files = map[string]VendorEntry{}
+	}
+	return index, nil
+}
+
+func (v *VendorIndex) Write(vendorDir string) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshalling vendor index")
+	}
+	indexPath := path.Join(vendorDir, VendorIndexFileName)
+	err = os.WriteFile(indexPath, append(b, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing vendor index at '"+indexPath+"'")
+	}
+	return nil
+}
+
+//UseVendorIndex makes the fetcher read the files of index from vendorDir instead of fetching them.
+//The vendored files keep the name they have when fetched from their source, so they are scoped
+//in the state and checked against the lock exactly as if they were fetched
+func (fetcher *Fetcher) UseVendorIndex(vendorDir string, index *VendorIndex) {
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+	for _, entry := range index.Files {
+		fetcher.vendoredUrls[path.Join(vendorDir, entry.Path)] = entry.ResolvedUrl
+	}
+	//first, so the urls are matched exactly as they are written in the templates
+	fetcher.UrlTransformer = append([]UrlTransformer{index.UrlTransformer(vendorDir)}, fetcher.UrlTransformer...)
+}
+
+func (v *VendorIndex) UrlTransformer(vendorDir string) UrlTransformer {
+	return func(url string) string {
+		if entry, ok := v.Files[url]; ok {
+			return path.Join(vendorDir, entry.Path)
+		}
+		return url
+	}
+}
+
+var unsafeVendorPathChars = regexp.MustCompile(`[^a-zA-Z0-9._/-]`)
+
+//VendorPath returns where a file fetched from resolvedUrl is stored, relative to the vendor directory.
+//The path always ends with the extension of the file, since that is how the parsers and templaters pick what they handle
+func VendorPath(resolvedUrl string) string {
+	ext := ExtractExtension(resolvedUrl)
+	p := resolvedUrl
+	if i := strings.Index(p, "://"); i != -1 {
+		p = p[i+3:]
+	}
+	p = unsafeVendorPathChars.ReplaceAllString(p, "_")
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			segments[i] = "_"
+		}
+	}
+	p = strings.Join(segments, "/")
+	if ext != "" && !strings.HasSuffix(p, ext) {
+		p += ext
+	}
+	return p
+}
@@ -114,17 +114,17 @@ func populateGcpToken(ctx context.Context, dataBag core.DataBag) (core.DataBag,
 			ObjectConst: []core.ObjectConstItem{
 				{
 					Key: "access_token",
-					Value: core.SyntaxToken{
+					Value: core.MarkSensitive(core.SyntaxToken{
 						Type:  core.TokenTypeLiteralValue,
 						Value: token.AccessToken,
-					},
+					}),
 				},
 				{
 					Key: "refresh_token",
-					Value: core.SyntaxToken{
+					Value: core.MarkSensitive(core.SyntaxToken{
 						Type:  core.TokenTypeLiteralValue,
 						Value: token.RefreshToken,
-					},
+					}),
 				},
 			},
 		},
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type GraphNodeKind = string
+type GraphEdgeKind = string
+
+const (
+	GraphNodeTemplate    GraphNodeKind = "template"
+	GraphNodeManifest    GraphNodeKind = "manifest"
+	GraphNodeComponent   GraphNodeKind = "component"
+	GraphNodeFile        GraphNodeKind = "file"
+	GraphNodeDatabagType GraphNodeKind = "databag_type"
+
+	//template -> manifest, manifest -> manifest/component/file
+	GraphEdgeIncludes GraphEdgeKind = "includes"
+	//component -> component, through import_component
+	GraphEdgeImports GraphEdgeKind = "imports"
+	//component/file -> databag type
+	GraphEdgeProduces GraphEdgeKind = "produces"
+	//databag type -> component, the component produced databags when given only databags of that type (among others) as input
+	GraphEdgeTriggers GraphEdgeKind = "triggers"
+	//databag type -> component, the component declares the type as one of its inputs
+	GraphEdgeConsumes GraphEdgeKind = "consumes"
+)
+
+type GraphNode struct {
+	Id   string        `json:"id"`
+	Kind GraphNodeKind `json:"kind"`
+	Name string        `json:"name"`
+}
+
+type GraphEdge struct {
+	From string        `json:"from"`
+	To   string        `json:"to"`
+	Kind GraphEdgeKind `json:"kind"`
+	//Steps are the lifecycle steps during which the edge was seen, only for edges recorded while running components
+	Steps []MakeLifecycleStep `json:"steps,omitempty"`
+}
+
+//DependencyGraph records what the maker included and ran, and which databag types came out of it.
+//It is safe to use concurrently, components are applied in parallel
+type DependencyGraph struct {
+	mutex sync.Mutex
+	nodes map[string]GraphNode
+	edges map[string]*GraphEdge
+}
+
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		nodes: map[string]GraphNode{},
+		edges: map[string]*GraphEdge{},
+	}
+}
+
+func GraphNodeId(kind GraphNodeKind, name string) string {
+	return kind + ":" + name
+}
+
+//AddEdge adds both nodes if they are not in the graph yet, step can be empty.
+//Recording on a nil graph does nothing
+func (g *DependencyGraph) AddEdge(fromKind GraphNodeKind, from string, toKind GraphNodeKind, to string, kind GraphEdgeKind, step MakeLifecycleStep) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	fromId := g.addNode(fromKind, from)
+	toId := g.addNode(toKind, to)
+	key := fromId + "\x00" + toId + "\x00" + kind
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &GraphEdge{
+			From: fromId,
+			To:   toId,
+			Kind: kind,
+		}
+		g.edges[key] = edge
+	}
+	if step != "" && !contains(edge.Steps, step) {
+		edge.Steps = append(edge.Steps, step)
+	}
+}
+
+//AddProduced records that producer emitted the databag types of output
+func (g *DependencyGraph) AddProduced(producerKind GraphNodeKind, producer string, output ConfigContainer, step MakeLifecycleStep) {
+	for databagType := range output.DataBags {
+		if contains(BarbeStateTypes, databagType) {
+			continue
+		}
+		g.AddEdge(producerKind, producer, GraphNodeDatabagType, databagType, GraphEdgeProduces, step)
+	}
+}
+
+//AddTriggered records that component produced databags in reaction to the databag types of input
+func (g *DependencyGraph) AddTriggered(component string, input ConfigContainer, step MakeLifecycleStep) {
+	for databagType := range input.DataBags {
+		if contains(BarbeStateTypes, databagType) {
+			continue
+		}
+		g.AddEdge(GraphNodeDatabagType, databagType, GraphNodeComponent, component, GraphEdgeTriggers, step)
+	}
+}
+
+//AddDeclaration records the declared inputs and outputs of a component
+func (g *DependencyGraph) AddDeclaration(component string, declaration ComponentDeclaration) {
+	for _, input := range declaration.Inputs {
+		g.AddEdge(GraphNodeDatabagType, input, GraphNodeComponent, component, GraphEdgeConsumes, "")
+	}
+	for _, output := range declaration.Outputs {
+		g.AddEdge(GraphNodeComponent, component, GraphNodeDatabagType, output, GraphEdgeProduces, "")
+	}
+}
+
+func (g *DependencyGraph) addNode(kind GraphNodeKind, name string) string {
+	id := GraphNodeId(kind, name)
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = GraphNode{
+			Id:   id,
+			Kind: kind,
+			Name: name,
+		}
+	}
+	return id
+}
+
+//MergeWith adds all the nodes and edges of other to g
+func (g *DependencyGraph) MergeWith(other *DependencyGraph) {
+	for _, edge := range other.Edges() {
+		from := other.node(edge.From)
+		to := other.node(edge.To)
+		if len(edge.Steps) == 0 {
+			g.AddEdge(from.Kind, from.Name, to.Kind, to.Name, edge.Kind, "")
+		}
+		for _, step := range edge.Steps {
+			g.AddEdge(from.Kind, from.Name, to.Kind, to.Name, edge.Kind, step)
+		}
+	}
+	for _, node := range other.Nodes() {
+		g.mutex.Lock()
+		g.addNode(node.Kind, node.Name)
+		g.mutex.Unlock()
+	}
+}
+
+func (g *DependencyGraph) node(id string) GraphNode {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.nodes[id]
+}
+
+//Nodes are sorted by id
+func (g *DependencyGraph) Nodes() []GraphNode {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	output := make([]GraphNode, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		output = append(output, node)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Id < output[j].Id
+	})
+	return output
+}
+
+//Edges are sorted by source, destination and kind
+func (g *DependencyGraph) Edges() []GraphEdge {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	output := make([]GraphEdge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		e := *edge
+		e.Steps = append([]MakeLifecycleStep{}, edge.Steps...)
+		output = append(output, e)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].From != output[j].From {
+			return output[i].From < output[j].From
+		}
+		if output[i].To != output[j].To {
+			return output[i].To < output[j].To
+		}
+		return output[i].Kind < output[j].Kind
+	})
+	return output
+}
+
+var graphNodeShapes = map[GraphNodeKind]string{
+	GraphNodeTemplate:    "doubleoctagon",
+	GraphNodeManifest:    "folder",
+	GraphNodeComponent:   "box",
+	GraphNodeFile:        "note",
+	GraphNodeDatabagType: "ellipse",
+}
+
+//WriteDot writes the graph in the graphviz format
+func (g *DependencyGraph) WriteDot(w io.Writer) error {
+	b := strings.Builder{}
+	b.WriteString("digraph barbe {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes() {
+		b.WriteString(fmt.Sprintf("  %s [label=%s, shape=%s];\n", strconv.Quote(node.Id), strconv.Quote(node.Name), graphNodeShapes[node.Kind]))
+	}
+	for _, edge := range g.Edges() {
+		label := edge.Kind
+		if len(edge.Steps) != 0 {
+			label += " (" + strings.Join(edge.Steps, ", ") + ")"
+		}
+		b.WriteString(fmt.Sprintf("  %s -> %s [label=%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), strconv.Quote(label)))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (g *DependencyGraph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Nodes []GraphNode `json:"nodes"`
+		Edges []GraphEdge `json:"edges"`
+	}{
+		Nodes: g.Nodes(),
+		Edges: g.Edges(),
+	})
+}
+
+func contains[T comparable](arr []T, v T) bool {
+	for _, item := range arr {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
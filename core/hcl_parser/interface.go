@@ -13,7 +13,7 @@ func (h HclParser) Name() string {
 }
 
 func (h HclParser) CanParse(ctx context.Context, fileDesc fetcher.FileDescription) (bool, error) {
-	l := fetcher.ExtractExtension(fileDesc.Name)
+	l := core.MakerFromContext(ctx).FileExtension(fileDesc.Name)
 	return l == ".hcl" || l == ".tf", nil
 }
 
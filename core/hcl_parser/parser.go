@@ -9,12 +9,13 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, userGeneratedFile fetcher.FileDescription) error {
 	userGenerated, diags := hclsyntax.ParseConfig(userGeneratedFile.Content, userGeneratedFile.Name, hcl.Pos{Line: 1, Column: 1})
 	if diags.HasErrors() {
-		return diags
+		return hclDiagnosticsToCore(diags)
 	}
 	userGeneratedBody, ok := userGenerated.Body.(*hclsyntax.Body)
 	if !ok {
@@ -32,7 +33,7 @@ func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, use
 	for _, attr := range userGeneratedBody.Attributes {
 		syntaxToken, err := hclExpressionToSyntaxToken(attr.Expr)
 		if err != nil {
-			return errors.Wrap(err, "error unmarshalling data item")
+			return locatedDiagnostic(attr.SrcRange, "error unmarshalling attribute '"+attr.Name+"'", err)
 		}
 		rootBag.Value.ObjectConst = append(rootBag.Value.ObjectConst, core.ObjectConstItem{
 			Key:   attr.Name,
@@ -40,6 +41,15 @@ func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, use
 		})
 	}
 	if len(userGeneratedBody.Attributes) != 0 {
+		rootProvenance := core.Provenance{
+			Kind:       core.ProvenanceFile,
+			Source:     userGeneratedFile.Name,
+			Attributes: map[string]*core.SourceRange{},
+		}
+		for _, attr := range userGeneratedBody.Attributes {
+			rootProvenance.Attributes[attr.Name] = hclRangeToCore(attr.SrcRange)
+		}
+		rootBag.Provenance = []core.Provenance{rootProvenance}
 		err := container.Insert(rootBag)
 		if err != nil {
 			return errors.Wrap(err, "error merging bag")
@@ -49,7 +59,7 @@ func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, use
 	for _, block := range userGeneratedBody.Blocks {
 		syntaxToken, err := blockToSyntaxToken(block, false)
 		if err != nil {
-			return errors.Wrap(err, "error unmarshalling data item")
+			return locatedDiagnostic(block.DefRange(), "error unmarshalling block '"+block.Type+"'", err)
 		}
 
 		name := ""
@@ -63,10 +73,11 @@ func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, use
 			block.Type = "provider(" + uuid.New().String() + ")"
 		}
 		bag := core.DataBag{
-			Name:   name,
-			Type:   block.Type,
-			Labels: block.Labels,
-			Value:  *syntaxToken,
+			Name:       name,
+			Type:       block.Type,
+			Labels:     block.Labels,
+			Value:      *syntaxToken,
+			Provenance: []core.Provenance{blockProvenance(userGeneratedFile.Name, block)},
 		}
 
 		err = container.Insert(bag)
@@ -76,3 +87,95 @@ func parseFromTemplate(ctx context.Context, container *core.ConfigContainer, use
 	}
 	return nil
 }
+
+func blockProvenance(fileName string, block *hclsyntax.Block) core.Provenance {
+	provenance := core.Provenance{
+		Kind:       core.ProvenanceFile,
+		Source:     fileName,
+		Range:      hclRangeToCore(block.Range()),
+		Attributes: map[string]*core.SourceRange{},
+	}
+	for _, attr := range block.Body.Attributes {
+		provenance.Attributes[attr.Name] = hclRangeToCore(attr.SrcRange)
+	}
+	for _, nested := range block.Body.Blocks {
+		if _, ok := provenance.Attributes[nested.Type]; !ok {
+			provenance.Attributes[nested.Type] = hclRangeToCore(nested.Range())
+		}
+	}
+	provenance.NestedAttributes = map[string]*core.SourceRange{}
+	for _, attr := range block.Body.Attributes {
+		addObjectRanges(provenance.NestedAttributes, attr.Name, attr.Expr)
+	}
+	addNestedRanges(provenance.NestedAttributes, "", block.Body)
+	return provenance
+}
+
+//addObjectRanges records the ranges of the items of object expressions, like `auto_scaling = { min = 1 }`
+func addObjectRanges(ranges map[string]*core.SourceRange, path string, expr hclsyntax.Expression) {
+	objectExpr, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return
+	}
+	for _, item := range objectExpr.Items {
+		key := hcl.ExprAsKeyword(item.KeyExpr)
+		if key == "" {
+			value, diags := item.KeyExpr.Value(nil)
+			if diags.HasErrors() || !value.Type().Equals(cty.String) || !value.IsKnown() || value.IsNull() {
+				continue
+			}
+			key = value.AsString()
+		}
+		itemPath := path + "." + key
+		ranges[itemPath] = hclRangeToCore(hcl.RangeBetween(item.KeyExpr.Range(), item.ValueExpr.Range()))
+		addObjectRanges(ranges, itemPath, item.ValueExpr)
+	}
+}
+
+//addNestedRanges records the ranges of the nested blocks of body and of their attributes,
+//the blocks of a type are indexed in the order they appear, like blockToSyntaxToken does
+func addNestedRanges(ranges map[string]*core.SourceRange, prefix string, body *hclsyntax.Body) {
+	counts := map[string]int{}
+	for _, nested := range body.Blocks {
+		path := fmt.Sprintf("%s%s[%d]", prefix, nested.Type, counts[nested.Type])
+		counts[nested.Type]++
+		ranges[path] = hclRangeToCore(nested.Range())
+		for _, attr := range nested.Body.Attributes {
+			ranges[path+"."+attr.Name] = hclRangeToCore(attr.SrcRange)
+			addObjectRanges(ranges, path+"."+attr.Name, attr.Expr)
+		}
+		addNestedRanges(ranges, path+".", nested.Body)
+	}
+}
+
+func hclRangeToCore(r hcl.Range) *core.SourceRange {
+	return &core.SourceRange{
+		Filename: r.Filename,
+		Start:    core.SourcePos{Line: r.Start.Line, Column: r.Start.Column, Byte: r.Start.Byte},
+		End:      core.SourcePos{Line: r.End.Line, Column: r.End.Column, Byte: r.End.Byte},
+	}
+}
+
+func hclDiagnosticsToCore(diags hcl.Diagnostics) core.Diagnostics {
+	output := make(core.Diagnostics, 0, len(diags))
+	for _, diag := range diags {
+		severity := core.DiagnosticSeverityError
+		if diag.Severity == hcl.DiagWarning {
+			severity = core.DiagnosticSeverityWarning
+		}
+		d := core.Diagnostic{
+			Severity: severity,
+			Summary:  diag.Summary,
+			Detail:   diag.Detail,
+		}
+		if diag.Subject != nil {
+			d.Range = hclRangeToCore(*diag.Subject)
+		}
+		output = append(output, d)
+	}
+	return output
+}
+
+func locatedDiagnostic(r hcl.Range, summary string, err error) core.Diagnostics {
+	return core.LocatedDiagnostics(hclRangeToCore(r), summary, err)
+}
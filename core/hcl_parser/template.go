@@ -0,0 +1,21 @@
+package hcl_parser
+
+import (
+	"barbe/core"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+//ParseTemplateString parses str as the content of an HCL string, so "${var.x}-suffix" gives the same token as in a .hcl file.
+//It lets parsers of other formats support traversals and interpolations, start is where str begins in the file
+func ParseTemplateString(str string, fileName string, start core.SourcePos) (core.SyntaxToken, error) {
+	expr, diags := hclsyntax.ParseTemplate([]byte(str), fileName, hcl.Pos{Line: start.Line, Column: start.Column, Byte: start.Byte})
+	if diags.HasErrors() {
+		return core.SyntaxToken{}, hclDiagnosticsToCore(diags)
+	}
+	token, err := hclExpressionToSyntaxToken(expr)
+	if err != nil {
+		return core.SyntaxToken{}, locatedDiagnostic(expr.Range(), "error parsing template", err)
+	}
+	return *token, nil
+}
@@ -0,0 +1,49 @@
+package hcl_parser
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"encoding/json"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+//ParseVariablesFile reads the values of a --var-file, written as HCL attributes like a .tfvars file,
+//or as a JSON object if the file name ends with .json
+func ParseVariablesFile(file fetcher.FileDescription) (map[string]core.SyntaxToken, error) {
+	output := map[string]core.SyntaxToken{}
+	if strings.HasSuffix(file.Name, ".json") {
+		var values map[string]interface{}
+		err := json.Unmarshal(file.Content, &values)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing variables file '"+file.Name+"'")
+		}
+		for name, value := range values {
+			token, err := core.GoValueToToken(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "error parsing variable '"+name+"'")
+			}
+			output[name] = token
+		}
+		return output, nil
+	}
+
+	parsed, diags := hclsyntax.ParseConfig(file.Content, file.Name, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, hclDiagnosticsToCore(diags)
+	}
+	body := parsed.Body.(*hclsyntax.Body)
+	if len(body.Blocks) != 0 {
+		return nil, locatedDiagnostic(body.Blocks[0].DefRange(), "variables files can only contain attributes", errors.New("unexpected block '"+body.Blocks[0].Type+"'"))
+	}
+	for name, attr := range body.Attributes {
+		token, err := hclExpressionToSyntaxToken(attr.Expr)
+		if err != nil {
+			return nil, locatedDiagnostic(attr.SrcRange, "error parsing variable '"+name+"'", err)
+		}
+		output[name] = *token
+	}
+	return output, nil
+}
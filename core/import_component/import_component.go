@@ -6,7 +6,6 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 	"os"
 	"sync"
 )
@@ -31,9 +30,8 @@ func (t *ComponentImporter) Name() string {
 
 func (t *ComponentImporter) Transform(ctx context.Context, data core.ConfigContainer) (core.ConfigContainer, error) {
 	output := core.NewConcurrentConfigContainer()
-	maker := ctx.Value("maker").(*core.Maker)
-	eg := errgroup.Group{}
-	eg.SetLimit(50)
+	maker := core.MakerFromContext(ctx)
+	eg, egCtx := core.NewErrorGroup(ctx, 50)
 	for resourceType, m := range data.DataBags {
 		if resourceType != bagName {
 			continue
@@ -133,9 +131,9 @@ func (t *ComponentImporter) Transform(ctx context.Context, data core.ConfigConta
 					if os.Getenv("BARBE_VERBOSE") == "1" {
 						log.Ctx(ctx).Debug().Msgf("importing component '%s'", file.Name)
 					}
-					newBags, err := maker.ApplyComponent(ctx, file, *input)
+					newBags, err := maker.ApplyComponent(egCtx, file, *input)
 					if err != nil {
-						return errors.Wrap(err, "error applying component '"+componentUrl+"'")
+						return core.NewComponentError(file.Name, maker.CurrentStep, errors.Wrap(err, "error applying imported component '"+componentUrl+"'"))
 					}
 					if newBags.IsEmpty() {
 						return nil
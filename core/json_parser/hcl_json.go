@@ -0,0 +1,271 @@
+package json_parser
+
+import (
+	"barbe/core"
+	"barbe/core/hcl_parser"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+type jsonKind int
+
+const (
+	jsonObject jsonKind = iota
+	jsonArray
+	jsonString
+	jsonNumber
+	jsonBool
+	jsonNull
+)
+
+//jsonNode is a JSON value with its location, properties keep the order of the file
+type jsonNode struct {
+	Kind jsonKind
+	//Value is set for the scalars
+	Value interface{}
+	Props []jsonProp
+	Items []*jsonNode
+	//Start and End are byte offsets in the file
+	Start int
+	End   int
+}
+
+type jsonProp struct {
+	Key       string
+	KeyOffset int
+	Value     *jsonNode
+}
+
+type jsonReader struct {
+	content []byte
+	decoder *json.Decoder
+}
+
+func readJson(content []byte) (*jsonNode, error) {
+	r := jsonReader{
+		content: content,
+		decoder: json.NewDecoder(bytes.NewReader(content)),
+	}
+	r.decoder.UseNumber()
+	tok, offset, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	root, err := r.readNode(tok, offset)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.decoder.Token()
+	if err != io.EOF {
+		return nil, errors.New("unexpected content after the top level object")
+	}
+	return root, nil
+}
+
+//next returns the next token and the offset it starts at, the decoder only gives the offset where the previous token ended
+func (r *jsonReader) next() (json.Token, int, error) {
+	start := int(r.decoder.InputOffset())
+	tok, err := r.decoder.Token()
+	if err != nil {
+		return nil, 0, err
+	}
+	for start < len(r.content) && strings.IndexByte(" \t\r\n:,", r.content[start]) != -1 {
+		start++
+	}
+	return tok, start, nil
+}
+
+func (r *jsonReader) readNode(tok json.Token, offset int) (*jsonNode, error) {
+	node := &jsonNode{Start: offset}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t != '{' && t != '[' {
+			return nil, &json.SyntaxError{Offset: int64(offset)}
+		}
+		for r.decoder.More() {
+			keyOffset := 0
+			key := ""
+			if t == '{' {
+				keyTok, o, err := r.next()
+				if err != nil {
+					return nil, err
+				}
+				key, _ = keyTok.(string)
+				keyOffset = o
+			}
+			valueTok, valueOffset, err := r.next()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.readNode(valueTok, valueOffset)
+			if err != nil {
+				return nil, err
+			}
+			if t == '{' {
+				node.Props = append(node.Props, jsonProp{Key: key, KeyOffset: keyOffset, Value: value})
+			} else {
+				node.Items = append(node.Items, value)
+			}
+		}
+		//closing delimiter
+		if _, _, err := r.next(); err != nil {
+			return nil, err
+		}
+		node.Kind = jsonArray
+		if t == '{' {
+			node.Kind = jsonObject
+		}
+	case string:
+		node.Kind = jsonString
+		node.Value = t
+	case json.Number:
+		node.Kind = jsonNumber
+		node.Value = t
+	case bool:
+		node.Kind = jsonBool
+		node.Value = t
+	case nil:
+		node.Kind = jsonNull
+	}
+	node.End = int(r.decoder.InputOffset())
+	return node, nil
+}
+
+type parser struct {
+	file core.SourceFile
+	//literal is set for the files given with core.ContextWithLiteralFiles
+	literal bool
+}
+
+//readBlocks goes down labelCount levels of labels and calls f with the body of each block found,
+//a level given as an array of objects is read as if its objects were merged
+func (p parser) readBlocks(node *jsonNode, keyOffset int, labelCount int, labels []string, f func(labels []string, keyOffset int, body *jsonNode) error) error {
+	if node.Kind == jsonArray && (labelCount > 0 || isArrayOfObjects(node)) {
+		for _, item := range node.Items {
+			if item.Kind != jsonObject {
+				return p.diagnostic(item, "invalid block", errors.New("expected an object"))
+			}
+			err := p.readBlocks(item, keyOffset, labelCount, labels, f)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if labelCount == 0 {
+		return f(labels, keyOffset, node)
+	}
+	if node.Kind != jsonObject {
+		return p.diagnostic(node, "invalid block", fmt.Errorf("expected an object for the label %d", len(labels)+1))
+	}
+	for _, prop := range node.Props {
+		if prop.Key == "//" {
+			continue
+		}
+		err := p.readBlocks(prop.Value, prop.KeyOffset, labelCount-1, append(append([]string{}, labels...), prop.Key), f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isArrayOfObjects(node *jsonNode) bool {
+	if node.Kind != jsonArray || len(node.Items) == 0 {
+		return false
+	}
+	for _, item := range node.Items {
+		if item.Kind != jsonObject {
+			return false
+		}
+	}
+	return true
+}
+
+//toToken converts a value, strings are parsed as HCL templates. "//" properties are comments in a block body only
+func (p parser) toToken(node *jsonNode, isBody bool) (core.SyntaxToken, error) {
+	switch node.Kind {
+	case jsonObject:
+		output := core.SyntaxToken{
+			Type:        core.TokenTypeObjectConst,
+			ObjectConst: make([]core.ObjectConstItem, 0, len(node.Props)),
+		}
+		for _, prop := range node.Props {
+			if isBody && !p.literal && prop.Key == "//" {
+				continue
+			}
+			item, err := p.toToken(prop.Value, false)
+			if err != nil {
+				return core.SyntaxToken{}, errors.Wrap(err, "error decoding key '"+prop.Key+"'")
+			}
+			output.ObjectConst = append(output.ObjectConst, core.ObjectConstItem{
+				Key:   prop.Key,
+				Value: item,
+			})
+		}
+		return output, nil
+	case jsonArray:
+		output := core.SyntaxToken{
+			Type:       core.TokenTypeArrayConst,
+			ArrayConst: make([]core.SyntaxToken, 0, len(node.Items)),
+		}
+		for i, itemNode := range node.Items {
+			item, err := p.toToken(itemNode, false)
+			if err != nil {
+				return core.SyntaxToken{}, errors.Wrap(err, fmt.Sprintf("error decoding index %d of array", i))
+			}
+			output.ArrayConst = append(output.ArrayConst, item)
+		}
+		return output, nil
+	case jsonString:
+		str := node.Value.(string)
+		if p.literal || (!strings.Contains(str, "${") && !strings.Contains(str, "%{")) {
+			return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: str}, nil
+		}
+		//the template starts after the opening quote
+		start := p.file.Pos(node.Start + 1)
+		return hcl_parser.ParseTemplateString(str, p.file.Name, start)
+	case jsonNumber:
+		f, err := node.Value.(json.Number).Float64()
+		if err != nil {
+			return core.SyntaxToken{}, errors.Wrap(err, "error decoding number")
+		}
+		return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: f}, nil
+	case jsonBool:
+		return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: node.Value}, nil
+	}
+	return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: nil}, nil
+}
+
+func (p parser) provenance(keyOffset int, body *jsonNode) core.Provenance {
+	return core.FileProvenance(p.file.Name, p.file.OffsetRange(keyOffset, body.End), body, p.children)
+}
+
+//children locates the properties from their key and the items of an array
+func (p parser) children(node *jsonNode) []core.NestedNode[*jsonNode] {
+	output := make([]core.NestedNode[*jsonNode], 0, len(node.Props)+len(node.Items))
+	for _, prop := range node.Props {
+		output = append(output, core.NestedNode[*jsonNode]{
+			Key:   prop.Key,
+			Range: p.file.OffsetRange(prop.KeyOffset, prop.Value.End),
+			Node:  prop.Value,
+		})
+	}
+	for i, item := range node.Items {
+		output = append(output, core.NestedNode[*jsonNode]{
+			Index:  i,
+			IsItem: true,
+			Range:  p.file.OffsetRange(item.Start, item.End),
+			Node:   item,
+		})
+	}
+	return output
+}
+
+func (p parser) diagnostic(node *jsonNode, summary string, err error) error {
+	return core.LocatedDiagnostics(p.file.OffsetRange(node.Start, node.End), summary, err)
+}
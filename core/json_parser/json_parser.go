@@ -6,10 +6,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 type JsonParser struct{}
@@ -19,57 +19,138 @@ func (j JsonParser) Name() string {
 }
 
 func (j JsonParser) CanParse(ctx context.Context, fileDesc fetcher.FileDescription) (bool, error) {
-	l := fetcher.ExtractExtension(fileDesc.Name)
+	l := core.MakerFromContext(ctx).FileExtension(fileDesc.Name)
 	return l == ".json", nil
 }
 
+//BlockLabelsKey declares how many labels the block types of the file have, ex: `"barbe_block_labels": { "cr_aws_iam_role": 2 }`.
+//The HCL JSON syntax leaves that to the schema of the application, and databags have no schema when they are parsed.
+//Types that aren't listed have one label, their name
+const BlockLabelsKey = "barbe_block_labels"
+
+//Parse follows the HCL JSON syntax: `{ "type": { "name": { "label": { ... } } } }` is the same as `type "name" "label" { ... }`,
+//any label level can be an array of objects to repeat a key, strings are templates and "//" properties are comments.
+//The files given with core.ContextWithLiteralFiles are plain JSON instead: `{ "type": { "name": value } }`
 func (j JsonParser) Parse(ctx context.Context, fileDesc fetcher.FileDescription, container *core.ConfigContainer) error {
-	var raw map[string]interface{}
-	if err := json.Unmarshal(fileDesc.Content, &raw); err != nil {
-		return errors.Wrap(err, "failed to parse json")
+	root, err := readJson(fileDesc.Content)
+	if err != nil {
+		return jsonErrorToDiagnostics(fileDesc, err)
+	}
+	p := parser{
+		file:    core.NewSourceFile(fileDesc.Name, fileDesc.Content),
+		literal: core.LiteralFilesFromContext(ctx),
+	}
+	if root.Kind != jsonObject {
+		return p.diagnostic(root, "the top level of a json file must be an object", errors.New("expected an object of databag types"))
+	}
+	if p.literal {
+		return p.parseLiteral(root, container)
 	}
 
-	for typeName, v := range raw {
-		var rawType interface{}
-		if err := mapstructure.Decode(v, &rawType); err != nil {
-			log.Ctx(ctx).Warn().Err(err).Msg("key '" + typeName + "' is not unmarshallable")
-			return errors.Wrap(err, "failed to parse json")
+	labelCounts := map[string]int{}
+	for _, prop := range root.Props {
+		if prop.Key != BlockLabelsKey {
+			continue
 		}
-		if mapType, ok := rawType.(map[string]interface{}); ok {
-			for name, tokenI := range mapType {
-				token, err := ParsedJsonToToken(tokenI)
+		if prop.Value.Kind != jsonObject {
+			return p.diagnostic(prop.Value, "invalid '"+BlockLabelsKey+"'", errors.New("expected an object of block types to number of labels"))
+		}
+		for _, count := range prop.Value.Props {
+			n, err := strconv.Atoi(fmt.Sprint(count.Value.Value))
+			if count.Value.Kind != jsonNumber || err != nil || n < 0 {
+				return p.diagnostic(count.Value, "invalid '"+BlockLabelsKey+"."+count.Key+"'", errors.New("expected a number of labels"))
+			}
+			labelCounts[count.Key] = n
+		}
+	}
+
+	for _, prop := range root.Props {
+		if prop.Key == BlockLabelsKey || prop.Key == "//" {
+			continue
+		}
+		typeName := prop.Key
+		insert := func(labels []string, keyOffset int, body *jsonNode) error {
+			return p.insert(container, typeName, labels, keyOffset, body)
+		}
+
+		labelCount, declared := labelCounts[typeName]
+		if !declared {
+			//the values that can't be blocks, like the lists of a manifest, give a databag with an empty name
+			if prop.Value.Kind != jsonObject {
+				err := insert([]string{}, prop.KeyOffset, prop.Value)
 				if err != nil {
-					return errors.Wrap(err, "failed to parse json")
-				}
-				bag := core.DataBag{
-					Name:   name,
-					Type:   typeName,
-					Labels: []string{},
-					Value:  token,
-				}
-				if err := container.Insert(bag); err != nil {
-					return errors.Wrap(err, "couldn't insert databag")
+					return err
 				}
+				continue
 			}
-		} else {
-			token, err := ParsedJsonToToken(rawType)
+			labelCount = 1
+		}
+		err := p.readBlocks(prop.Value, prop.KeyOffset, labelCount, []string{}, insert)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parseLiteral reads the keys of the top level as types and the keys below them as databag names,
+//a type whose value isn't an object gives a databag with an empty name
+func (p parser) parseLiteral(root *jsonNode, container *core.ConfigContainer) error {
+	for _, typeProp := range root.Props {
+		if typeProp.Value.Kind != jsonObject {
+			err := p.insert(container, typeProp.Key, []string{}, typeProp.KeyOffset, typeProp.Value)
 			if err != nil {
-				return errors.Wrap(err, "failed to parse json")
+				return err
 			}
-			bag := core.DataBag{
-				Name:   "",
-				Type:   typeName,
-				Labels: []string{},
-				Value:  token,
-			}
-			if err := container.Insert(bag); err != nil {
-				return errors.Wrap(err, "couldn't insert databag")
+			continue
+		}
+		for _, nameProp := range typeProp.Value.Props {
+			err := p.insert(container, typeProp.Key, []string{nameProp.Key}, nameProp.KeyOffset, nameProp.Value)
+			if err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
+//insert adds the databag of type typeName, the first label is its name
+func (p parser) insert(container *core.ConfigContainer, typeName string, labels []string, keyOffset int, body *jsonNode) error {
+	token, err := p.toToken(body, true)
+	if err != nil {
+		return p.diagnostic(body, "error parsing '"+strings.Join(append([]string{typeName}, labels...), ".")+"'", err)
+	}
+	bag := core.DataBag{
+		Name:       "",
+		Type:       typeName,
+		Labels:     []string{},
+		Value:      token,
+		Provenance: []core.Provenance{p.provenance(keyOffset, body)},
+	}
+	if len(labels) > 0 {
+		bag.Name = labels[0]
+		bag.Labels = labels[1:]
+	}
+	if err := container.Insert(bag); err != nil {
+		return errors.Wrap(err, "couldn't insert databag")
+	}
+	return nil
+}
+
+func jsonErrorToDiagnostics(fileDesc fetcher.FileDescription, err error) error {
+	var offset int64
+	switch mErr := err.(type) {
+	case *json.SyntaxError:
+		offset = mErr.Offset
+	case *json.UnmarshalTypeError:
+		offset = mErr.Offset
+	default:
+		return errors.Wrap(err, "failed to parse json")
+	}
+	file := core.NewSourceFile(fileDesc.Name, fileDesc.Content)
+	return core.LocatedDiagnostics(file.OffsetRange(int(offset), int(offset)), "failed to parse json", err)
+}
+
 func ParsedJsonToToken(v interface{}) (core.SyntaxToken, error) {
 	if core.InterfaceIsNil(v) {
 		return core.SyntaxToken{
@@ -0,0 +1,164 @@
+package json_parser
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"testing"
+)
+
+func parse(t *testing.T, ctx context.Context, content string) *core.ConfigContainer {
+	t.Helper()
+	container := core.NewConfigContainer()
+	err := JsonParser{}.Parse(ctx, fetcher.FileDescription{Name: "test.json", Content: []byte(content)}, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return container
+}
+
+func attribute(t *testing.T, bag core.DataBag, key string) core.SyntaxToken {
+	t.Helper()
+	values := core.GetObjectKeyValues(key, bag.Value.ObjectConst)
+	if len(values) != 1 {
+		t.Fatalf("expected one '%s' attribute on %s.%s, got %d", key, bag.Type, bag.Name, len(values))
+	}
+	return values[0]
+}
+
+func TestParseLabels(t *testing.T) {
+	container := parse(t, context.Background(), `{
+		"barbe_block_labels": { "cr_aws_iam_role": 2, "provider": 0 },
+		"cr_aws_iam_role": { "x": { "y": { "name": "role" } } },
+		"aws_function": { "api": { "handler": "index.handler" } },
+		"provider": { "region": "us-east-1" },
+		"files": ["a.hcl", "b.hcl"]
+	}`)
+
+	roles := container.GetDataBagGroup("cr_aws_iam_role", "x")
+	if len(roles) != 1 || len(roles[0].Labels) != 1 || roles[0].Labels[0] != "y" {
+		t.Fatalf("expected cr_aws_iam_role \"x\" \"y\", got %+v", roles)
+	}
+	if _, ok := container.DataBags["barbe_block_labels"]; ok {
+		t.Error("barbe_block_labels must not be a databag")
+	}
+	functions := container.GetDataBagGroup("aws_function", "api")
+	if len(functions) != 1 || len(functions[0].Labels) != 0 {
+		t.Fatalf("expected aws_function \"api\", got %+v", functions)
+	}
+	providers := container.GetDataBagGroup("provider", "")
+	if len(providers) != 1 || attribute(t, providers[0], "region").Value != "us-east-1" {
+		t.Fatalf("expected an unnamed provider, got %+v", providers)
+	}
+	files := container.GetDataBagGroup("files", "")
+	if len(files) != 1 || files[0].Value.Type != core.TokenTypeArrayConst || len(files[0].Value.ArrayConst) != 2 {
+		t.Fatalf("expected an unnamed files array, got %+v", files)
+	}
+}
+
+func TestParseBlockArrays(t *testing.T) {
+	container := parse(t, context.Background(), `{
+		"barbe_block_labels": { "cr_aws_iam_role": 2 },
+		"aws_function": {
+			"api": [
+				{ "handler": "index.handler" },
+				{ "memory": 512 }
+			]
+		},
+		"cr_aws_iam_role": [
+			{ "x": { "y": { "name": "a" } } },
+			{ "x": [{ "z": { "name": "b" } }] }
+		]
+	}`)
+
+	//like two blocks with the same labels in HCL, the objects are merged
+	functions := container.GetDataBagGroup("aws_function", "api")
+	if len(functions) != 1 {
+		t.Fatalf("expected a single aws_function \"api\", got %d", len(functions))
+	}
+	if attribute(t, functions[0], "handler").Value != "index.handler" || attribute(t, functions[0], "memory").Value != float64(512) {
+		t.Errorf("unexpected aws_function values %+v", functions)
+	}
+	roles := container.GetDataBagGroup("cr_aws_iam_role", "x")
+	if len(roles) != 2 || roles[0].Labels[0] != "y" || roles[1].Labels[0] != "z" {
+		t.Fatalf("expected cr_aws_iam_role \"x\" \"y\" and \"x\" \"z\", got %+v", roles)
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	container := parse(t, context.Background(), `{
+		"aws_function": {
+			"api": {
+				"//": "a comment",
+				"name": "api-${var.stage}",
+				"stage": "${var.stage}",
+				"escaped": "$${var.stage}",
+				"plain": "index.handler"
+			}
+		}
+	}`)
+
+	bag := container.GetDataBagGroup("aws_function", "api")[0]
+	if len(core.GetObjectKeyValues("//", bag.Value.ObjectConst)) != 0 {
+		t.Error("'//' properties must be left out of block bodies")
+	}
+	name := attribute(t, bag, "name")
+	if name.Type != core.TokenTypeTemplate || len(name.Parts) != 2 || name.Parts[1].Type != core.TokenTypeScopeTraversal {
+		t.Errorf("expected a template with a traversal, got %+v", name)
+	}
+	if stage := attribute(t, bag, "stage"); stage.Type != core.TokenTypeScopeTraversal {
+		t.Errorf("expected a traversal, got %+v", stage)
+	}
+	escaped := ""
+	for _, part := range attribute(t, bag, "escaped").Parts {
+		if part.Type != core.TokenTypeLiteralValue {
+			t.Errorf("expected only literal parts in the escaped template, got %+v", part)
+		}
+		escaped += part.Value.(string)
+	}
+	if escaped != "${var.stage}" {
+		t.Errorf("expected the literal '${var.stage}', got '%s'", escaped)
+	}
+	if plain := attribute(t, bag, "plain"); plain.Type != core.TokenTypeLiteralValue || plain.Value != "index.handler" {
+		t.Errorf("expected the literal 'index.handler', got %+v", plain)
+	}
+
+	r := bag.Provenance[0].AttributeRange([]string{"name"})
+	if r == nil || r.Start.Line != 5 {
+		t.Errorf("expected 'name' to be located on line 5, got %v", r)
+	}
+}
+
+func TestParseLiteralFiles(t *testing.T) {
+	ctx := core.ContextWithLiteralFiles(context.Background())
+	container := parse(t, ctx, `{
+		"barbe_block_labels": { "output": 2 },
+		"output": {
+			"build": {
+				"//": "kept",
+				"command": "echo ${HOME} %{if}",
+				"steps": [{ "name": "a" }, { "name": "b" }],
+				"nested": { "x": { "y": 1 } }
+			}
+		}
+	}`)
+
+	outputs := container.GetDataBagGroup("output", "build")
+	if len(outputs) != 1 || len(outputs[0].Labels) != 0 {
+		t.Fatalf("expected a single output \"build\" without labels, got %+v", outputs)
+	}
+	bag := outputs[0]
+	if command := attribute(t, bag, "command"); command.Type != core.TokenTypeLiteralValue || command.Value != "echo ${HOME} %{if}" {
+		t.Errorf("expected the command as written, got %+v", command)
+	}
+	attribute(t, bag, "//")
+	if steps := attribute(t, bag, "steps"); steps.Type != core.TokenTypeArrayConst || len(steps.ArrayConst) != 2 {
+		t.Errorf("expected the steps array, got %+v", steps)
+	}
+	if nested := attribute(t, bag, "nested"); nested.Type != core.TokenTypeObjectConst {
+		t.Errorf("expected the nested object, got %+v", nested)
+	}
+	if len(container.GetDataBagGroup("barbe_block_labels", "output")) != 1 {
+		t.Error("barbe_block_labels is a regular key in literal files")
+	}
+}
@@ -14,7 +14,7 @@ func (h JsonnetTemplater) Name() string {
 }
 
 func (h JsonnetTemplater) Apply(ctx context.Context, maker *core.Maker, input core.ConfigContainer, template fetcher.FileDescription) (core.ConfigContainer, error) {
-	if fetcher.ExtractExtension(template.Name) != ".jsonnet" {
+	if maker.FileExtension(template.Name) != ".jsonnet" {
 		c := core.NewConfigContainer()
 		return *c, nil
 	}
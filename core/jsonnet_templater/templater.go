@@ -56,7 +56,7 @@ func createVm(ctx context.Context, maker *core.Maker, input core.ConfigContainer
 	vm.ExtCode("barbe", Builtins)
 	vm.ExtVar("barbe_command", maker.Command)
 	vm.ExtVar("barbe_lifecycle_step", maker.CurrentStep)
-	vm.ExtVar("barbe_output_dir", ctx.Value("maker").(*core.Maker).OutputDir)
+	vm.ExtVar("barbe_output_dir", core.MakerFromContext(ctx).OutputDir)
 	vm.ExtCode("env", string(env))
 	vm.ExtVar("barbe_selected_pipeline", "")
 	vm.ExtVar("barbe_selected_pipeline_step", "")
@@ -164,7 +164,11 @@ func executeJsonnet(ctx context.Context, maker *core.Maker, input core.ConfigCon
 					//var task *trace.Task
 					var span opentracing.Span
 					if os.Getenv("BARBE_TRACE") != "" {
-						b, err := json.Marshal(stepInput)
+						redacted, err := stepInput.Redacted()
+						if err != nil {
+							return errors.Wrap(err, "failed to redact input for trace")
+						}
+						b, err := json.Marshal(redacted)
 						if err != nil {
 							return errors.Wrap(err, "failed to marshal input for trace")
 						}
@@ -180,7 +184,7 @@ func executeJsonnet(ctx context.Context, maker *core.Maker, input core.ConfigCon
 						//	return errors.Wrap(err, "failed to marshal input for trace")
 						//}
 						//trace.Log(traceCtx, "input", string(b))
-						//trace.Log(traceCtx, "command", ctx.Value("maker").(*core.Maker).CurrentStep)
+						//trace.Log(traceCtx, "command", core.MakerFromContext(ctx).CurrentStep)
 					}
 
 					err = populateStateAndContainerInVm(maker, vm, core.ContextScopeKey(ctx), *stepInput)
@@ -226,7 +230,11 @@ func executeJsonnet(ctx context.Context, maker *core.Maker, input core.ConfigCon
 					}
 
 					if os.Getenv("BARBE_TRACE") != "" {
-						b, err := json.Marshal(output)
+						redacted, err := output.Redacted()
+						if err != nil {
+							return errors.Wrap(err, "failed to redact output for trace")
+						}
+						b, err := json.Marshal(redacted)
 						if err != nil {
 							return errors.Wrap(err, "failed to marshal output for trace")
 						}
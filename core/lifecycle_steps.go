@@ -0,0 +1,203 @@
+package core
+
+import (
+	"barbe/core/fetcher"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+//MakeCommandRun runs the generate phase and a single other step, see Maker.RunStep
+const MakeCommandRun = "run"
+
+var builtinLifecycleSteps = []MakeLifecycleStep{
+	MakeLifecycleStepPreGenerate,
+	MakeLifecycleStepGenerate,
+	MakeLifecycleStepPostGenerate,
+	MakeLifecycleStepPreDo,
+	MakeLifecycleStepPreApply,
+	MakeLifecycleStepApply,
+	MakeLifecycleStepPostApply,
+	MakeLifecycleStepPreDestroy,
+	MakeLifecycleStepDestroy,
+	MakeLifecycleStepPostDestroy,
+	MakeLifecycleStepPostDo,
+}
+
+//LifecycleStepDeclaration is a step declared in the template block, ex: `steps = [{ name = "plan", after = "pre_do" }]`.
+//It runs right after (or right before) another step, whenever that step runs
+type LifecycleStepDeclaration struct {
+	Name   MakeLifecycleStep
+	After  MakeLifecycleStep
+	Before MakeLifecycleStep
+}
+
+func (d LifecycleStepDeclaration) anchor() MakeLifecycleStep {
+	if d.After != "" {
+		return d.After
+	}
+	return d.Before
+}
+
+func interpretLifecycleSteps(token SyntaxToken) ([]LifecycleStepDeclaration, error) {
+	entries := []SyntaxToken{token}
+	if token.Type == TokenTypeArrayConst {
+		entries = token.ArrayConst
+	}
+	output := make([]LifecycleStepDeclaration, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Type != TokenTypeObjectConst {
+			return nil, fmt.Errorf("step %d must be an object like { name = \"plan\", after = \"pre_do\" }", i)
+		}
+		step := LifecycleStepDeclaration{}
+		for _, pair := range entry.ObjectConst {
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("error parsing '%s' of step %d", pair.Key, i))
+			}
+			switch pair.Key {
+			case "name":
+				step.Name = str
+			case "after":
+				step.After = str
+			case "before":
+				step.Before = str
+			}
+		}
+		if step.Name == "" {
+			return nil, fmt.Errorf("step %d has no 'name'", i)
+		}
+		if (step.After == "") == (step.Before == "") {
+			return nil, fmt.Errorf("step '%s' must have either 'after' or 'before'", step.Name)
+		}
+		output = append(output, step)
+	}
+	return output, nil
+}
+
+//validateLifecycleSteps checks the declared steps don't shadow a builtin step and are anchored to a known step
+func validateLifecycleSteps(steps []LifecycleStepDeclaration) error {
+	byName := map[string]LifecycleStepDeclaration{}
+	for _, step := range steps {
+		if contains(builtinLifecycleSteps, step.Name) {
+			return fmt.Errorf("step '%s' is already a builtin step", step.Name)
+		}
+		if _, ok := byName[step.Name]; ok {
+			return fmt.Errorf("step '%s' is declared several times", step.Name)
+		}
+		byName[step.Name] = step
+	}
+	for _, step := range steps {
+		if contains(builtinLifecycleSteps, step.anchor()) {
+			continue
+		}
+		if _, ok := byName[step.anchor()]; !ok {
+			return unknownStepError(step.anchor(), steps)
+		}
+		//follow the anchors up to a builtin step, to catch steps anchored to each other
+		seen := map[string]struct{}{step.Name: {}}
+		for current := byName[step.anchor()]; ; current = byName[current.anchor()] {
+			if _, ok := seen[current.Name]; ok {
+				return fmt.Errorf("step '%s' is anchored to itself through '%s'", step.Name, current.Name)
+			}
+			seen[current.Name] = struct{}{}
+			if contains(builtinLifecycleSteps, current.anchor()) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func unknownStepError(name string, declared []LifecycleStepDeclaration) error {
+	known := append([]string{}, builtinLifecycleSteps...)
+	for _, step := range declared {
+		known = append(known, step.Name)
+	}
+	msg := "unknown step '" + name + "'"
+	if suggestion := closestName(name, known); suggestion != "" {
+		msg += ", did you mean '" + suggestion + "'?"
+	}
+	return errors.New(msg)
+}
+
+//insertLifecycleSteps adds the declared steps next to the steps they are anchored to,
+//the steps anchored to a step that doesn't run are left out
+func insertLifecycleSteps(base []MakeLifecycleStep, declared []LifecycleStepDeclaration) []MakeLifecycleStep {
+	output := append([]MakeLifecycleStep{}, base...)
+	inserted := map[string]LifecycleStepDeclaration{}
+	for {
+		progress := false
+		for _, step := range declared {
+			if _, ok := inserted[step.Name]; ok {
+				continue
+			}
+			index := indexOf(output, step.anchor())
+			if index == -1 {
+				continue
+			}
+			if step.After != "" {
+				//steps declared earlier with the same anchor come first
+				index++
+				for index < len(output) && inserted[output[index]].After == step.After {
+					index++
+				}
+			}
+			output = append(output[:index], append([]MakeLifecycleStep{step.Name}, output[index:]...)...)
+			inserted[step.Name] = step
+			progress = true
+		}
+		if !progress {
+			return output
+		}
+	}
+}
+
+func indexOf(arr []string, value string) int {
+	for i, v := range arr {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+//RunStep runs the generate phase like Make does, then the given step alone.
+//The step can be a builtin step or one declared in the template block
+func (maker *Maker) RunStep(ctx context.Context, inputFiles []fetcher.FileDescription, step MakeLifecycleStep) (*ConfigContainer, error) {
+	container, err := maker.prepareContainer(ctx, inputFiles)
+	if err != nil {
+		return container, err
+	}
+	if !contains(builtinLifecycleSteps, step) && !containsStep(maker.Executable.Steps, step) {
+		return container, unknownStepError(step, maker.Executable.Steps)
+	}
+
+	generateSteps := maker.lifecycleSteps(MakeLifecycleStepPreGenerate, MakeLifecycleStepGenerate, MakeLifecycleStepPostGenerate)
+	err = maker.runGeneratePhase(ctx, container, generateSteps)
+	if err != nil {
+		return container, err
+	}
+	if contains(generateSteps, step) {
+		return container, nil
+	}
+	err = maker.runLifecycleStep(ctx, container, step)
+	if err != nil {
+		return container, err
+	}
+	return container, nil
+}
+
+func containsStep(steps []LifecycleStepDeclaration, name MakeLifecycleStep) bool {
+	for _, step := range steps {
+		if step.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//lifecycleSteps returns the given builtin steps with the declared steps anchored to them
+func (maker *Maker) lifecycleSteps(builtin ...MakeLifecycleStep) []MakeLifecycleStep {
+	return insertLifecycleSteps(builtin, maker.Executable.Steps)
+}
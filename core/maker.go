@@ -8,7 +8,10 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -64,6 +67,9 @@ type Maker struct {
 	Command      MakeCommand
 	CurrentStep  MakeLifecycleStep
 	OutputDir    string
+	//BaseDir is the directory relative paths (plugins, the build context of containers) are resolved against,
+	//the working directory of the process if empty
+	BaseDir      string
 	Parsers      []Parser
 	Templaters   []TemplateEngine
 	Transformers []Transformer
@@ -73,21 +79,148 @@ type Maker struct {
 	StateHandler *StateHandler
 	Executable   Executable
 	Env          map[string]string
+	//Graph records the manifests, components and databag types seen while making
+	Graph *DependencyGraph
+	//ComponentCache is disabled if nil
+	ComponentCache *ComponentCache
+	//ComponentLimits apply to every component, unless overridden by a component_limits block
+	ComponentLimits ComponentLimits
+	//Variables are the values given for the variable blocks (--var, --var-file), --var values are strings
+	//converted to the declared type of the variable
+	Variables map[string]SyntaxToken
+
+	//AllTemplateEntries makes GetTemplates ignore the when conditions of the template entries, so vendor
+	//and lock capture the entries of every env and command
+	AllTemplateEntries bool
+
+	//DryRun makes the side effects (running containers, persisting state) be recorded instead of executed
+	DryRun bool
+	//SkipFormatters runs the generate steps without the formatters writing the generated files, for the commands
+	//that only inspect what a run does
+	SkipFormatters bool
+
+	//Plugins are also registered in Parsers, Transformers and/or Formatters
+	Plugins []*Plugin
+	//Recording receives what the run consumes, if not nil
+	Recording *Recording
+	//Replaying makes the state stores and containers be replaced by what was recorded
+	Replaying *Recording
+
+	//StateDisplay tracks the progress of the lifecycle steps, for the CLI to display it
+	StateDisplay *state_display.StateDisplay
+
+	declarationsMutex sync.Mutex
+	pluginsMutex      sync.Mutex
+	sensitive         sensitiveRegistry
+	//inputFiles are the names of the files given to Make, Validate or RunStep
+	inputFiles map[string]struct{}
 }
 
 func NewMaker(command MakeCommand, mFetcher *fetcher.Fetcher) *Maker {
 	maker := &Maker{
 		Command: command,
 		Fetcher: mFetcher,
+		Graph:   NewDependencyGraph(),
+
+		StateDisplay: state_display.NewStateDisplay(),
+	}
+	maker.StateHandler = newStateHandlerWithMemory(maker)
+	return maker
+}
+
+//Reset drops what a previous Make accumulated (state, executable) so the maker can be run again.
+//Parsers, templaters and the fetcher are kept, transformers holding state should be replaced by the caller
+func (maker *Maker) Reset() {
+	maker.CurrentStep = ""
+	maker.Executable = Executable{}
+	maker.StateHandler = newStateHandlerWithMemory(maker)
+	maker.Graph = NewDependencyGraph()
+}
+
+//Close releases the templaters that hold resources and stops the plugins, the maker can't be used afterwards
+func (maker *Maker) Close() error {
+	var firstErr error
+	for _, templater := range maker.Templaters {
+		closer, ok := templater.(io.Closer)
+		if !ok {
+			continue
+		}
+		err := closer.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "error closing templater '"+templater.Name()+"'")
+		}
+	}
+	maker.pluginsMutex.Lock()
+	defer maker.pluginsMutex.Unlock()
+	for _, plugin := range maker.Plugins {
+		err := plugin.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "error closing plugin '"+plugin.Name()+"'")
+		}
+	}
+	return firstErr
+}
+
+//ResolvePath returns p joined to BaseDir if p is relative
+func (maker *Maker) ResolvePath(p string) string {
+	if maker.BaseDir == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(maker.BaseDir, p)
+}
+
+//FileExtension is fetcher.ExtractExtension for a file name that may be relative to BaseDir, since the extension
+//of a local file is only given if the file exists. maker can be nil
+func (maker *Maker) FileExtension(name string) string {
+	ext := fetcher.ExtractExtension(name)
+	if ext != "" || maker == nil || maker.BaseDir == "" || filepath.IsAbs(name) {
+		return ext
 	}
+	return fetcher.ExtractExtension(maker.ResolvePath(name))
+}
+
+//IsInputFile tells if name is one of the files given by the user, as opposed to the files referenced
+//by the template block, the manifests or the files read back from containers
+func (maker *Maker) IsInputFile(name string) bool {
+	_, ok := maker.inputFiles[name]
+	return ok
+}
+
+type makerContextKey struct{}
+
+//ContextWithMaker gives the maker to the parsers, templaters, transformers and formatters it runs
+func ContextWithMaker(ctx context.Context, maker *Maker) context.Context {
+	return context.WithValue(ctx, makerContextKey{}, maker)
+}
+
+//MakerFromContext returns the maker given to ContextWithMaker, or nil
+func MakerFromContext(ctx context.Context) *Maker {
+	maker, _ := ctx.Value(makerContextKey{}).(*Maker)
+	return maker
+}
+
+type literalFilesContextKey struct{}
+
+//ContextWithLiteralFiles tells the parsers the files were written by a program, like the files read back from
+//containers, not by the user: their strings aren't templates and JSON is plain JSON instead of the HCL JSON syntax
+func ContextWithLiteralFiles(ctx context.Context) context.Context {
+	return context.WithValue(ctx, literalFilesContextKey{}, true)
+}
+
+//LiteralFilesFromContext tells if ContextWithLiteralFiles was used
+func LiteralFilesFromContext(ctx context.Context) bool {
+	literal, _ := ctx.Value(literalFilesContextKey{}).(bool)
+	return literal
+}
+
+func newStateHandlerWithMemory(maker *Maker) *StateHandler {
 	stateHandler := NewStateHandler(maker)
 	//we always add a memory persister in case some templates rely on the state "API" to pass values between steps
 	err := stateHandler.AddPersister(NewMemoryStatePersister())
 	if err != nil {
 		panic(err)
 	}
-	maker.StateHandler = stateHandler
-	return maker
+	return stateHandler
 }
 
 func (maker *Maker) Make(ctx context.Context, inputFiles []fetcher.FileDescription) (c *ConfigContainer, e error) {
@@ -118,7 +251,62 @@ func (maker *Maker) Make(ctx context.Context, inputFiles []fetcher.FileDescripti
 		ctx = opentracing.ContextWithSpan(ctx, span)
 	}
 
+	container, err := maker.prepareContainer(ctx, inputFiles)
+	if err != nil {
+		return container, err
+	}
+
+	err = maker.runGeneratePhase(ctx, container, maker.lifecycleSteps(MakeLifecycleStepPreGenerate, MakeLifecycleStepGenerate, MakeLifecycleStepPostGenerate))
+	if err != nil {
+		return container, err
+	}
+	if maker.Command == MakeCommandGenerate {
+		return container, nil
+	}
+
+	steps := []MakeLifecycleStep{MakeLifecycleStepPreDo}
+	switch maker.Command {
+	case MakeCommandApply:
+		steps = append(steps, MakeLifecycleStepPreApply, MakeLifecycleStepApply, MakeLifecycleStepPostApply)
+	case MakeCommandDestroy:
+		steps = append(steps, MakeLifecycleStepPreDestroy, MakeLifecycleStepDestroy, MakeLifecycleStepPostDestroy)
+	default:
+		return container, errors.New("unknown command '" + maker.Command + "'")
+	}
+	steps = append(steps, MakeLifecycleStepPostDo)
+
+	for _, step := range maker.lifecycleSteps(steps...) {
+		err = maker.runLifecycleStep(ctx, container, step)
+		if err != nil {
+			return container, err
+		}
+	}
+	return container, nil
+}
+
+//Validate parses the input files, resolves the templates and runs the components up to the generate step.
+//Formatters are not run, errors located in the input files are returned as Diagnostics
+func (maker *Maker) Validate(ctx context.Context, inputFiles []fetcher.FileDescription) (*ConfigContainer, error) {
+	container, err := maker.prepareContainer(ctx, inputFiles)
+	if err != nil {
+		return container, err
+	}
+	for _, step := range []MakeLifecycleStep{MakeLifecycleStepPreGenerate, MakeLifecycleStepGenerate} {
+		err = maker.runLifecycleStep(ctx, container, step)
+		if err != nil {
+			return container, err
+		}
+	}
+	return container, nil
+}
+
+//prepareContainer parses the input files, resolves the template block and parses the files it references
+func (maker *Maker) prepareContainer(ctx context.Context, inputFiles []fetcher.FileDescription) (*ConfigContainer, error) {
 	maker.CurrentStep = MakeLifecycleStepPreGenerate
+	maker.inputFiles = make(map[string]struct{}, len(inputFiles))
+	for _, file := range inputFiles {
+		maker.inputFiles[file.Name] = struct{}{}
+	}
 	container := NewConfigContainer()
 	err := maker.ParseFiles(ctx, inputFiles, container)
 	if err != nil {
@@ -133,6 +321,11 @@ func (maker *Maker) Make(ctx context.Context, inputFiles []fetcher.FileDescripti
 	}
 	maker.Executable = executable
 
+	err = maker.loadTemplatePlugins(ctx, executable.Plugins)
+	if err != nil {
+		return container, errors.Wrap(err, "error loading plugin declared in the template block")
+	}
+
 	if executable.Message != "" {
 		log.Ctx(ctx).Info().Msg(executable.Message)
 	}
@@ -142,132 +335,74 @@ func (maker *Maker) Make(ctx context.Context, inputFiles []fetcher.FileDescripti
 		return container, errors.Wrap(err, "error parsing files from manifest")
 	}
 
-	err = maker.TransformInPlace(ctx, container)
+	maker.Executable.DefaultLimits, maker.Executable.Limits, err = maker.readComponentLimits(*container)
 	if err != nil {
 		return container, err
 	}
 
-	state_display.GlobalState.StartMajorStep("pre_generate")
-	//this is pre_generate
-	err = maker.ApplyComponents(ctx, container)
+	diags, err := ValidateSchemas(*container)
 	if err != nil {
-		state_display.GlobalState.EndMajorStepWith("pre_generate", true)
 		return container, err
 	}
-	state_display.GlobalState.EndMajorStep("pre_generate")
+	if diags.HasErrors() {
+		return container, diags
+	}
 
-	state_display.GlobalState.StartMajorStep("generate")
-	maker.CurrentStep = MakeLifecycleStepGenerate
-	err = maker.ApplyComponents(ctx, container)
+	err = maker.resolveVariables(ctx, container)
 	if err != nil {
-		state_display.GlobalState.EndMajorStepWith("generate", true)
 		return container, err
 	}
-	state_display.GlobalState.EndMajorStep("generate")
 
-	state_display.GlobalState.StartMajorStep("post_generate")
-	maker.CurrentStep = MakeLifecycleStepPostGenerate
-	err = maker.ApplyComponents(ctx, container)
+	err = maker.TransformInPlace(ctx, container)
 	if err != nil {
-		state_display.GlobalState.EndMajorStepWith("post_generate", true)
 		return container, err
 	}
-	state_display.GlobalState.EndMajorStep("post_generate")
+	return container, nil
+}
 
-	for _, formatter := range maker.Formatters {
-		log.Ctx(ctx).Debug().Msgf("formatting %s", formatter.Name())
-		err := formatter.Format(ctx, *container)
+//runGeneratePhase runs the given steps then the formatters, unless SkipFormatters is set
+func (maker *Maker) runGeneratePhase(ctx context.Context, container *ConfigContainer, steps []MakeLifecycleStep) error {
+	for _, step := range steps {
+		err := maker.runLifecycleStep(ctx, container, step)
 		if err != nil {
-			return container, err
+			return err
 		}
 	}
-	if maker.Command == MakeCommandGenerate {
-		return container, nil
-	}
-
-	state_display.GlobalState.StartMajorStep("pre_do")
-	maker.CurrentStep = MakeLifecycleStepPreDo
-	err = maker.ApplyComponents(ctx, container)
-	if err != nil {
-		state_display.GlobalState.EndMajorStepWith("pre_do", true)
-		return container, err
+	if maker.SkipFormatters {
+		return nil
 	}
-	state_display.GlobalState.EndMajorStep("pre_do")
-
-	switch maker.Command {
-	case MakeCommandApply:
-		state_display.GlobalState.StartMajorStep("pre_apply")
-		maker.CurrentStep = MakeLifecycleStepPreApply
-		err = maker.ApplyComponents(ctx, container)
-		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("pre_apply", true)
-			return container, err
-		}
-		state_display.GlobalState.EndMajorStep("pre_apply")
-
-		state_display.GlobalState.StartMajorStep("apply")
-		maker.CurrentStep = MakeLifecycleStepApply
-		err = maker.ApplyComponents(ctx, container)
-		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("apply", true)
-			return container, err
-		}
-		state_display.GlobalState.EndMajorStep("apply")
-
-		state_display.GlobalState.StartMajorStep("post_apply")
-		maker.CurrentStep = MakeLifecycleStepPostApply
-		err = maker.ApplyComponents(ctx, container)
-		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("post_apply", true)
-			return container, err
-		}
-		state_display.GlobalState.EndMajorStep("post_apply")
-
-	case MakeCommandDestroy:
-		state_display.GlobalState.StartMajorStep("pre_destroy")
-		maker.CurrentStep = MakeLifecycleStepPreDestroy
-		err = maker.ApplyComponents(ctx, container)
-		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("pre_destroy", true)
-			return container, err
-		}
-		state_display.GlobalState.EndMajorStep("pre_destroy")
-
-		state_display.GlobalState.StartMajorStep("destroy")
-		maker.CurrentStep = MakeLifecycleStepDestroy
-		err = maker.ApplyComponents(ctx, container)
-		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("destroy", true)
-			return container, err
-		}
-		state_display.GlobalState.EndMajorStep("destroy")
-
-		state_display.GlobalState.StartMajorStep("post_destroy")
-		maker.CurrentStep = MakeLifecycleStepPostDestroy
-		err = maker.ApplyComponents(ctx, container)
+	for _, formatter := range maker.Formatters {
+		log.Ctx(ctx).Debug().Msgf("formatting %s", formatter.Name())
+		err := formatter.Format(ctx, *container)
 		if err != nil {
-			state_display.GlobalState.EndMajorStepWith("post_destroy", true)
-			return container, err
+			return err
 		}
-		state_display.GlobalState.EndMajorStep("post_destroy")
-	default:
-		return container, errors.New("unknown command '" + maker.Command + "'")
 	}
+	return nil
+}
 
-	state_display.GlobalState.StartMajorStep("post_do")
-	maker.CurrentStep = MakeLifecycleStepPostDo
-	err = maker.ApplyComponents(ctx, container)
+func (maker *Maker) runLifecycleStep(ctx context.Context, container *ConfigContainer, step MakeLifecycleStep) error {
+	maker.StateDisplay.StartMajorStep(step)
+	maker.CurrentStep = step
+	err := maker.ApplyComponents(ctx, container)
 	if err != nil {
-		state_display.GlobalState.EndMajorStepWith("post_do", true)
-		return container, err
+		maker.StateDisplay.EndMajorStepWith(step, true)
+		return err
 	}
-	state_display.GlobalState.EndMajorStep("post_do")
-
-	return container, nil
+	maker.StateDisplay.EndMajorStep(step)
+	return nil
 }
 
+//ParseFiles keeps going on files that produce Diagnostics, so all the located errors are reported at once
 func (maker *Maker) ParseFiles(ctx context.Context, files []fetcher.FileDescription, container *ConfigContainer) error {
+	return maker.parseFiles(ctx, files, container, true)
+}
+
+//parseFiles records the databag types of each file in the graph if recordInGraph is true
+func (maker *Maker) parseFiles(ctx context.Context, files []fetcher.FileDescription, container *ConfigContainer, recordInGraph bool) error {
+	var diags Diagnostics
 	for _, file := range files {
+		fileContainer := NewConfigContainer()
 		for _, parser := range maker.Parsers {
 			canParse, err := parser.CanParse(ctx, file)
 			if err != nil {
@@ -277,11 +412,31 @@ func (maker *Maker) ParseFiles(ctx context.Context, files []fetcher.FileDescript
 				continue
 			}
 			log.Ctx(ctx).Debug().Msgf("parsing '%s' with '%s'", file.Name, parser.Name())
-			err = parser.Parse(ctx, file, container)
+			err = parser.Parse(ctx, file, fileContainer)
 			if err != nil {
+				var fileDiags Diagnostics
+				if errors.As(err, &fileDiags) {
+					diags = append(diags, fileDiags...)
+					continue
+				}
 				return err
 			}
 		}
+		//parsers that track locations set a more precise provenance themselves
+		fileContainer.SetMissingProvenance(Provenance{
+			Kind:   ProvenanceFile,
+			Source: file.Name,
+		})
+		if recordInGraph {
+			maker.Graph.AddProduced(GraphNodeFile, file.Name, *fileContainer, "")
+		}
+		err := container.MergeWith(*fileContainer)
+		if err != nil {
+			return errors.Wrap(err, "error merging databags of '"+file.Name+"'")
+		}
+	}
+	if diags.HasErrors() {
+		return diags
 	}
 	err := maker.StateHandler.HandleStateDatabags(ctx, container)
 	if err != nil {
@@ -292,6 +447,9 @@ func (maker *Maker) ParseFiles(ctx context.Context, files []fetcher.FileDescript
 
 //Transform returns the new or modified databags produced by the transformers
 func (maker *Maker) Transform(ctx context.Context, container ConfigContainer) (newOrModifiedBags ConfigContainer, e error) {
+	//when a component runs the transformers itself (ex: the transformContainer rpc), their side effects
+	//would be skipped if its output was reused
+	markComponentUncacheable(ctx)
 	err := maker.StateHandler.HandleStateDatabags(ctx, &container)
 	if err != nil {
 		return ConfigContainer{}, errors.Wrap(err, "error creating persisters")
@@ -305,6 +463,8 @@ func (maker *Maker) Transform(ctx context.Context, container ConfigContainer) (n
 		if err != nil {
 			return ConfigContainer{}, err
 		}
+		newBags.SetMissingProvenance(maker.transformerProvenance(transformer))
+		maker.RegisterSensitiveValues(newBags)
 		err = output.MergeWith(newBags)
 		if err != nil {
 			return ConfigContainer{}, err
@@ -327,6 +487,8 @@ func (maker *Maker) TransformInPlace(ctx context.Context, container *ConfigConta
 		if err != nil {
 			return err
 		}
+		newBags.SetMissingProvenance(maker.transformerProvenance(transformer))
+		maker.RegisterSensitiveValues(newBags)
 		err = container.MergeWith(newBags)
 		if err != nil {
 			return err
@@ -334,3 +496,11 @@ func (maker *Maker) TransformInPlace(ctx context.Context, container *ConfigConta
 	}
 	return nil
 }
+
+func (maker *Maker) transformerProvenance(transformer Transformer) Provenance {
+	return Provenance{
+		Kind:   ProvenanceTransformer,
+		Source: transformer.Name(),
+		Step:   maker.CurrentStep,
+	}
+}
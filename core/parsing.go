@@ -6,16 +6,30 @@ import (
 )
 
 type TemplateBlock struct {
-	Files      []string
-	Components []string
-	Manifests  []string
+	Files      []TemplateEntry
+	Components []TemplateEntry
+	Manifests  []TemplateEntry
+	Steps      []LifecycleStepDeclaration
+	Plugins    []string
 }
 
-func parseTemplateBlock(templateConfig []DataBag) (TemplateBlock, error) {
+//TemplateEntry is an element of the files, components or manifests lists, written as a url
+//or as an object like `{ url = "...", when = env.STAGE == "prod", params = { ... } }`
+type TemplateEntry struct {
+	Url string
+	//Params are given to a manifest, which references them as params.<name>
+	Params map[string]interface{}
+	//Object is the entry when written as an object, component entries also hold their declaration in it
+	Object *SyntaxToken
+}
+
+//parseTemplateBlock reads the template blocks, the entries are evaluated against scope (see Maker.templateScope),
+//see interpretTemplateEntries for allEntries
+func parseTemplateBlock(templateConfig []DataBag, scope map[string]interface{}, allEntries bool) (TemplateBlock, error) {
 	template := TemplateBlock{
-		Files:      []string{},
-		Components: []string{},
-		Manifests:  []string{},
+		Files:      []TemplateEntry{},
+		Components: []TemplateEntry{},
+		Manifests:  []TemplateEntry{},
 	}
 	for _, t := range templateConfig {
 		attrs, err := extractBlockAttrs(t.Value)
@@ -34,7 +48,7 @@ func parseTemplateBlock(templateConfig []DataBag) (TemplateBlock, error) {
 		}
 		fileKeyValues := GetObjectKeysValues(fileKeys, attrs)
 		for _, fileSyntax := range fileKeyValues {
-			files, err := interpretAsStrArray(fileSyntax)
+			files, err := interpretTemplateEntries(fileSyntax, scope, allEntries)
 			if err != nil {
 				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%sfiles'", name))
 			}
@@ -47,9 +61,9 @@ func parseTemplateBlock(templateConfig []DataBag) (TemplateBlock, error) {
 		}
 		templateKeyValues := GetObjectKeysValues(templateKeys, attrs)
 		for _, templateSyntax := range templateKeyValues {
-			templates, err := interpretAsStrArray(templateSyntax)
+			templates, err := interpretTemplateEntries(templateSyntax, scope, allEntries)
 			if err != nil {
-				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%stemplates'", name))
+				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%scomponents'", name))
 			}
 			template.Components = append(template.Components, templates...)
 		}
@@ -60,12 +74,32 @@ func parseTemplateBlock(templateConfig []DataBag) (TemplateBlock, error) {
 		}
 		manifestKeyValues := GetObjectKeysValues(manifestKeys, attrs)
 		for _, manifestSyntax := range manifestKeyValues {
-			manifests, err := interpretAsStrArray(manifestSyntax)
+			manifests, err := interpretTemplateEntries(manifestSyntax, scope, allEntries)
 			if err != nil {
 				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%smanifest'", name))
 			}
 			template.Manifests = append(template.Manifests, manifests...)
 		}
+
+		for _, stepsSyntax := range GetObjectKeyValues("steps", attrs) {
+			steps, err := interpretLifecycleSteps(stepsSyntax)
+			if err != nil {
+				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%ssteps'", name))
+			}
+			template.Steps = append(template.Steps, steps...)
+		}
+
+		for _, pluginsSyntax := range GetObjectKeyValues("plugins", attrs) {
+			plugins, err := interpretAsStrArray(pluginsSyntax)
+			if err != nil {
+				return template, errors.Wrap(err, fmt.Sprintf("error parsing 'template.%splugins'", name))
+			}
+			template.Plugins = append(template.Plugins, plugins...)
+		}
+	}
+	err := validateLifecycleSteps(template.Steps)
+	if err != nil {
+		return template, errors.Wrap(err, "error parsing 'template.steps'")
 	}
 	return template, nil
 }
@@ -87,6 +121,89 @@ func extractBlockAttrs(blockArr SyntaxToken) ([]ObjectConstItem, error) {
 	return items, nil
 }
 
+//interpretTemplateEntries evaluates the url, when and params of each entry against scope,
+//the entries whose when condition is false are left out, unless allEntries is true
+func interpretTemplateEntries(token SyntaxToken, scope map[string]interface{}, allEntries bool) ([]TemplateEntry, error) {
+	entries := []SyntaxToken{token}
+	if token.Type == TokenTypeArrayConst {
+		entries = token.ArrayConst
+	}
+	output := make([]TemplateEntry, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Type != TokenTypeObjectConst {
+			url, err := evaluateAsString(entry, scope)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("couldn't interpret element %d as string", i))
+			}
+			output = append(output, TemplateEntry{Url: url})
+			continue
+		}
+
+		whenTokens := GetObjectKeyValues("when", entry.ObjectConst)
+		if allEntries {
+			whenTokens = nil
+		}
+		selected := true
+		for _, whenToken := range whenTokens {
+			when, err := EvaluateExpressionInScope(whenToken, scope)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("error evaluating 'when' of element %d", i))
+			}
+			b, ok := when.(bool)
+			if !ok {
+				return nil, fmt.Errorf("'when' of element %d must be a bool, got %s", i, describeValue(when))
+			}
+			selected = selected && b
+		}
+		if !selected {
+			continue
+		}
+
+		urlTokens := GetObjectKeyValues("url", entry.ObjectConst)
+		if len(urlTokens) == 0 {
+			return nil, fmt.Errorf("element %d has no 'url'", i)
+		}
+		url, err := evaluateAsString(urlTokens[0], scope)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("couldn't interpret 'url' of element %d as string", i))
+		}
+		templateEntry := TemplateEntry{
+			Url:    url,
+			Object: TokenPtr(entry),
+		}
+		for _, paramsToken := range GetObjectKeyValues("params", entry.ObjectConst) {
+			params, err := EvaluateExpressionInScope(paramsToken, scope)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("error evaluating 'params' of element %d", i))
+			}
+			paramsMap, ok := params.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("'params' of element %d must be an object, got %s", i, describeValue(params))
+			}
+			if templateEntry.Params == nil {
+				templateEntry.Params = map[string]interface{}{}
+			}
+			for k, v := range paramsMap {
+				templateEntry.Params[k] = v
+			}
+		}
+		output = append(output, templateEntry)
+	}
+	return output, nil
+}
+
+func evaluateAsString(token SyntaxToken, scope map[string]interface{}) (string, error) {
+	v, err := EvaluateExpressionInScope(token, scope)
+	if err != nil {
+		return "", err
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %s", describeValue(v))
+	}
+	return str, nil
+}
+
 func interpretAsStrArray(token SyntaxToken) ([]string, error) {
 	output := make([]string, 0)
 	if token.Type == TokenTypeArrayConst {
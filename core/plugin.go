@@ -0,0 +1,448 @@
+package core
+
+import (
+	"barbe/core/fetcher"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ Plugins are executables extending barbe with parsers, transformers and formatters without recompiling it.
+ A plugin is started once and kept running, barbe writes one JSON request per line on its stdin
+ and the plugin writes one JSON response per line on its stdout. Its stderr is forwarded to barbe's.
+ The plugin must exit when its stdin is closed.
+
+ The first request is always `{"method":"describe","protocol_version":1}`, to which the plugin answers
+ with its name and what it implements: `{"name":"mesh_fmt","kinds":["formatter"]}`.
+ The other requests depend on the kinds:
+  - parser: `can_parse` and `parse` with a `file` ({"Name": "...", "Content": "<base64>"}),
+    answered with `{"can_parse":true}` and `{"container":{...}}` respectively
+  - transformer: `transform` with a `container`, answered with `{"container":{...}}` holding the new or modified databags
+  - formatter: `format` with a `container`, answered with `{}`
+ `transform` and `format` also receive a `context` with the command, lifecycle step and output directory.
+ Any response can contain an `error` string to fail the request.
+ A plugin that doesn't answer a request within its timeout is killed.
+*/
+const PluginProtocolVersion = 1
+
+const (
+	DefaultPluginTimeout = 10 * time.Minute
+	//how long a plugin has to exit once its stdin is closed, before being killed
+	pluginExitTimeout = 5 * time.Second
+)
+
+type PluginKind = string
+
+const (
+	PluginKindParser      PluginKind = "parser"
+	PluginKindTransformer PluginKind = "transformer"
+	PluginKindFormatter   PluginKind = "formatter"
+)
+
+type pluginContext struct {
+	Command       MakeCommand       `json:"command"`
+	LifecycleStep MakeLifecycleStep `json:"lifecycle_step"`
+	OutputDir     string            `json:"output_dir"`
+}
+
+type pluginRequest struct {
+	Method          string                   `json:"method"`
+	ProtocolVersion int                      `json:"protocol_version,omitempty"`
+	File            *fetcher.FileDescription `json:"file,omitempty"`
+	Container       *ConfigContainer         `json:"container,omitempty"`
+	Context         *pluginContext           `json:"context,omitempty"`
+}
+
+type pluginResponse struct {
+	Error     string           `json:"error,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Kinds     []PluginKind     `json:"kinds,omitempty"`
+	CanParse  bool             `json:"can_parse,omitempty"`
+	Container *ConfigContainer `json:"container,omitempty"`
+}
+
+//Plugin implements Parser, Transformer and Formatter by forwarding the calls to the plugin process,
+//it should only be registered for the kinds the plugin described
+type Plugin struct {
+	Path string
+	//Timeout of each request, 0 means no timeout
+	Timeout time.Duration
+	name    string
+	kinds   []PluginKind
+	//fromTemplate is true for the plugins declared in a template block, they are unloaded when the declaration is removed
+	fromTemplate bool
+
+	//the process handles one request at a time
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	//killed is set once the process was killed for not answering, the following requests fail right away
+	killed bool
+	//exited is closed once the process exited, waitErr is set before
+	exited  chan struct{}
+	waitErr error
+}
+
+//StartPlugin starts the executable at pluginPath and asks it what it implements
+func StartPlugin(ctx context.Context, pluginPath string) (*Plugin, error) {
+	cmd := exec.Command(pluginPath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating stdin pipe")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating stdout pipe")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting plugin '"+pluginPath+"'")
+	}
+	plugin := &Plugin{
+		Path:    pluginPath,
+		Timeout: DefaultPluginTimeout,
+		name:    path.Base(pluginPath),
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		plugin.waitErr = cmd.Wait()
+		close(plugin.exited)
+	}()
+	resp, err := plugin.call(ctx, pluginRequest{
+		Method:          "describe",
+		ProtocolVersion: PluginProtocolVersion,
+	})
+	if err != nil {
+		plugin.Close()
+		return nil, err
+	}
+	if resp.Name != "" {
+		plugin.name = resp.Name
+	}
+	plugin.kinds = resp.Kinds
+	log.Ctx(ctx).Debug().Msgf("started plugin '%s' from '%s' implementing %v", plugin.name, pluginPath, plugin.kinds)
+	return plugin, nil
+}
+
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+func (p *Plugin) Kinds() []PluginKind {
+	return p.kinds
+}
+
+//Close asks the plugin to exit by closing its stdin, and kills it if it doesn't exit in time
+func (p *Plugin) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(pluginExitTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+		return errors.New("plugin '" + p.name + "' didn't exit after its stdin was closed, it was killed")
+	}
+	if p.killed {
+		return nil
+	}
+	return p.waitErr
+}
+
+func (p *Plugin) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.killed {
+		return pluginResponse{}, errors.New("plugin '" + p.name + "' was killed after a previous request didn't complete")
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return pluginResponse{}, errors.Wrap(err, "error marshalling request to plugin '"+p.name+"'")
+	}
+	type result struct {
+		line []byte
+		err  error
+	}
+	//the exchange runs on its own so a plugin that never answers can be killed, which unblocks it
+	done := make(chan result, 1)
+	go func() {
+		_, err := p.stdin.Write(append(b, '\n'))
+		if err != nil {
+			done <- result{err: errors.Wrap(err, "error writing request to plugin '"+p.name+"'")}
+			return
+		}
+		//bufio.Scanner doesn't work here because it breaks if the received data is too large
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			err = errors.Wrap(err, "error reading response of plugin '"+p.name+"' to '"+req.Method+"'")
+		}
+		done <- result{line: line, err: err}
+	}()
+	var timeout <-chan time.Time
+	if p.Timeout != 0 {
+		timer := time.NewTimer(p.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var line []byte
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return pluginResponse{}, res.err
+		}
+		line = res.line
+	case <-ctx.Done():
+		p.kill()
+		return pluginResponse{}, errors.Wrap(ctx.Err(), "plugin '"+p.name+"' was killed while answering '"+req.Method+"'")
+	case <-timeout:
+		p.kill()
+		return pluginResponse{}, fmt.Errorf("plugin '%s' didn't answer '%s' within %s, it was killed", p.name, req.Method, p.Timeout)
+	}
+	var resp pluginResponse
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		return pluginResponse{}, errors.Wrap(err, "error parsing response of plugin '"+p.name+"' to '"+req.Method+"'")
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("plugin '%s' failed to %s: %s", p.name, req.Method, resp.Error)
+	}
+	return resp, nil
+}
+
+//kill must be called with the mutex held, the process can't be trusted to answer the following requests
+func (p *Plugin) kill() {
+	p.killed = true
+	p.cmd.Process.Kill()
+}
+
+func makePluginContext(ctx context.Context) *pluginContext {
+	maker := MakerFromContext(ctx)
+	if maker == nil {
+		return nil
+	}
+	return &pluginContext{
+		Command:       maker.Command,
+		LifecycleStep: maker.CurrentStep,
+		OutputDir:     maker.OutputDir,
+	}
+}
+
+func (p *Plugin) CanParse(ctx context.Context, fileDesc fetcher.FileDescription) (bool, error) {
+	resp, err := p.call(ctx, pluginRequest{
+		Method: "can_parse",
+		File:   &fileDesc,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.CanParse, nil
+}
+
+func (p *Plugin) Parse(ctx context.Context, fileDesc fetcher.FileDescription, container *ConfigContainer) error {
+	resp, err := p.call(ctx, pluginRequest{
+		Method: "parse",
+		File:   &fileDesc,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Container == nil {
+		return nil
+	}
+	return container.MergeWith(*resp.Container)
+}
+
+func (p *Plugin) Transform(ctx context.Context, container ConfigContainer) (ConfigContainer, error) {
+	resp, err := p.call(ctx, pluginRequest{
+		Method:    "transform",
+		Container: &container,
+		Context:   makePluginContext(ctx),
+	})
+	if err != nil {
+		return ConfigContainer{}, err
+	}
+	if resp.Container == nil {
+		return *NewConfigContainer(), nil
+	}
+	return *resp.Container, nil
+}
+
+func (p *Plugin) Format(ctx context.Context, container ConfigContainer) error {
+	_, err := p.call(ctx, pluginRequest{
+		Method:    "format",
+		Container: &container,
+		Context:   makePluginContext(ctx),
+	})
+	return err
+}
+
+//AddPlugin registers the plugin as a parser, transformer and/or formatter depending on what it implements.
+//Plugins are identified by path, adding the same one twice does nothing
+func (maker *Maker) AddPlugin(plugin *Plugin) bool {
+	maker.pluginsMutex.Lock()
+	defer maker.pluginsMutex.Unlock()
+	for _, existing := range maker.Plugins {
+		if existing.Path == plugin.Path {
+			return false
+		}
+	}
+	maker.Plugins = append(maker.Plugins, plugin)
+	if contains(plugin.kinds, PluginKindParser) {
+		maker.Parsers = append(maker.Parsers, plugin)
+	}
+	if contains(plugin.kinds, PluginKindTransformer) {
+		maker.Transformers = append(maker.Transformers, plugin)
+	}
+	if contains(plugin.kinds, PluginKindFormatter) {
+		maker.Formatters = append(maker.Formatters, plugin)
+	}
+	return true
+}
+
+//removePlugin unregisters the plugin from the parsers, transformers and formatters, and closes it
+func (maker *Maker) removePlugin(ctx context.Context, plugin *Plugin) {
+	maker.pluginsMutex.Lock()
+	isPlugin := func(v any) bool {
+		p, ok := v.(*Plugin)
+		return ok && p == plugin
+	}
+	maker.Plugins = filter(maker.Plugins, func(p *Plugin) bool { return p != plugin })
+	maker.Parsers = filter(maker.Parsers, func(p Parser) bool { return !isPlugin(p) })
+	maker.Transformers = filter(maker.Transformers, func(t Transformer) bool { return !isPlugin(t) })
+	maker.Formatters = filter(maker.Formatters, func(f Formatter) bool { return !isPlugin(f) })
+	maker.pluginsMutex.Unlock()
+	err := plugin.Close()
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("error closing plugin '" + plugin.Name() + "'")
+	}
+}
+
+//PluginTransformers are the plugins to add back after replacing Maker.Transformers
+func (maker *Maker) PluginTransformers() []Transformer {
+	maker.pluginsMutex.Lock()
+	defer maker.pluginsMutex.Unlock()
+	output := make([]Transformer, 0)
+	for _, plugin := range maker.Plugins {
+		if contains(plugin.kinds, PluginKindTransformer) {
+			output = append(output, plugin)
+		}
+	}
+	return output
+}
+
+//LoadPlugin starts the plugin at pluginPath unless it's already loaded
+func (maker *Maker) LoadPlugin(ctx context.Context, pluginPath string) error {
+	return maker.loadPlugin(ctx, pluginPath, false)
+}
+
+func (maker *Maker) loadPlugin(ctx context.Context, pluginPath string, fromTemplate bool) error {
+	pluginPath, err := filepath.Abs(maker.ResolvePath(pluginPath))
+	if err != nil {
+		return errors.Wrap(err, "error resolving plugin path")
+	}
+	maker.pluginsMutex.Lock()
+	for _, existing := range maker.Plugins {
+		if existing.Path == pluginPath {
+			maker.pluginsMutex.Unlock()
+			return nil
+		}
+	}
+	maker.pluginsMutex.Unlock()
+	plugin, err := StartPlugin(ctx, pluginPath)
+	if err != nil {
+		return err
+	}
+	plugin.fromTemplate = fromTemplate
+	if !maker.AddPlugin(plugin) {
+		plugin.Close()
+	}
+	return nil
+}
+
+//loadTemplatePlugins loads the plugins declared in the template block, and unloads the ones
+//loaded from a previous template block that are not declared anymore (ex: in watch mode)
+func (maker *Maker) loadTemplatePlugins(ctx context.Context, pluginPaths []string) error {
+	declared := map[string]struct{}{}
+	for _, pluginPath := range pluginPaths {
+		absPath, err := filepath.Abs(maker.ResolvePath(pluginPath))
+		if err != nil {
+			return errors.Wrap(err, "error resolving plugin path")
+		}
+		declared[absPath] = struct{}{}
+	}
+	maker.pluginsMutex.Lock()
+	removed := make([]*Plugin, 0)
+	for _, plugin := range maker.Plugins {
+		if _, ok := declared[plugin.Path]; plugin.fromTemplate && !ok {
+			removed = append(removed, plugin)
+		}
+	}
+	maker.pluginsMutex.Unlock()
+	for _, plugin := range removed {
+		log.Ctx(ctx).Debug().Msgf("unloading plugin '%s', it's not declared in the template block anymore", plugin.Name())
+		maker.removePlugin(ctx, plugin)
+	}
+	for _, pluginPath := range pluginPaths {
+		err := maker.loadPlugin(ctx, pluginPath, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//FindPlugins lists the executables in dir, a missing dir has no plugins
+func FindPlugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "error reading plugins dir '"+dir+"'")
+	}
+	output := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading plugin '"+entry.Name()+"'")
+		}
+		if info.Mode()&0111 == 0 {
+			continue
+		}
+		output = append(output, path.Join(dir, entry.Name()))
+	}
+	sort.Strings(output)
+	return output, nil
+}
+
+func filter[T any](arr []T, keep func(T) bool) []T {
+	output := make([]T, 0, len(arr))
+	for _, v := range arr {
+		if keep(v) {
+			output = append(output, v)
+		}
+	}
+	return output
+}
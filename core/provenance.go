@@ -0,0 +1,186 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ProvenanceKind = string
+
+const (
+	ProvenanceFile        ProvenanceKind = "file"
+	ProvenanceComponent   ProvenanceKind = "component"
+	ProvenanceTransformer ProvenanceKind = "transformer"
+)
+
+//Provenance is one of the contributors of a databag, a databag merged from several sources keeps all of them
+type Provenance struct {
+	Kind ProvenanceKind
+	//Source is the file name, the component url or the transformer name
+	Source string
+	//Step is the lifecycle step during which a component or transformer produced the databag
+	Step MakeLifecycleStep `json:",omitempty"`
+	//Range is only known for files parsed by a parser that tracks locations
+	Range *SourceRange `json:",omitempty"`
+	//Attributes are the top level attributes set by this contributor, with their location when known
+	Attributes map[string]*SourceRange `json:",omitempty"`
+	//NestedAttributes are the attributes and blocks below the top level, keyed by path like `container[0].image`
+	NestedAttributes map[string]*SourceRange `json:",omitempty"`
+}
+
+//AttributeRange returns the location of the attribute at path (as in NestedAttributes) or of its closest known parent
+func (p Provenance) AttributeRange(path []string) *SourceRange {
+	for i := len(path); i > 1; i-- {
+		if r := p.NestedAttributes[strings.Join(path[:i], ".")]; r != nil {
+			return r
+		}
+	}
+	if len(path) != 0 {
+		name := path[0]
+		if i := strings.Index(name, "["); i != -1 {
+			name = name[:i]
+		}
+		if r := p.Attributes[name]; r != nil {
+			return r
+		}
+	}
+	return p.Range
+}
+
+func (p Provenance) String() string {
+	str := ""
+	switch p.Kind {
+	case ProvenanceFile:
+		str = p.Source
+		if p.Range != nil {
+			str = p.Range.String()
+		}
+	default:
+		str = p.Kind + " '" + p.Source + "'"
+	}
+	if p.Step != "" {
+		str += " during " + p.Step
+	}
+	return str
+}
+
+//AttributeNames are sorted
+func (p Provenance) AttributeNames() []string {
+	names := make([]string, 0, len(p.Attributes))
+	for name := range p.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p Provenance) key() string {
+	rangeStr := ""
+	if p.Range != nil {
+		rangeStr = p.Range.String()
+	}
+	return strings.Join([]string{p.Kind, p.Source, p.Step, rangeStr}, "\x00")
+}
+
+//NestedNode is a property (with its Key) or a list item (with its Index) of a value of a parsed file
+type NestedNode[T any] struct {
+	Key    string
+	Index  int
+	IsItem bool
+	//Range locates the property from its key, or the item
+	Range *SourceRange
+	Node  T
+}
+
+//FileProvenance locates a databag parsed from a file and its attributes, children gives the
+//properties and items of a value of the file
+func FileProvenance[T any](fileName string, r *SourceRange, body T, children func(node T) []NestedNode[T]) Provenance {
+	provenance := Provenance{
+		Kind:             ProvenanceFile,
+		Source:           fileName,
+		Range:            r,
+		Attributes:       map[string]*SourceRange{},
+		NestedAttributes: map[string]*SourceRange{},
+	}
+	for _, child := range children(body) {
+		if child.IsItem {
+			continue
+		}
+		provenance.Attributes[child.Key] = child.Range
+		AddNestedRanges(provenance.NestedAttributes, child.Key, child.Node, children)
+	}
+	return provenance
+}
+
+//AddNestedRanges records the ranges below path, keyed like the NestedAttributes of the HCL parser: `container[0].image`
+func AddNestedRanges[T any](ranges map[string]*SourceRange, path string, node T, children func(node T) []NestedNode[T]) {
+	for _, child := range children(node) {
+		childPath := path + "." + child.Key
+		if child.IsItem {
+			childPath = fmt.Sprintf("%s[%d]", path, child.Index)
+		}
+		ranges[childPath] = child.Range
+		AddNestedRanges(ranges, childPath, child.Node, children)
+	}
+}
+
+//mergeProvenance always returns a new slice, the inputs can be shared between clones of a container
+func mergeProvenance(a []Provenance, b []Provenance) []Provenance {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	output := make([]Provenance, 0, len(a)+len(b))
+	indexes := map[string]int{}
+	for _, p := range append(append([]Provenance{}, a...), b...) {
+		i, ok := indexes[p.key()]
+		if !ok {
+			indexes[p.key()] = len(output)
+			output = append(output, p)
+			continue
+		}
+		if len(p.Attributes) == 0 {
+			continue
+		}
+		attributes := make(map[string]*SourceRange, len(output[i].Attributes)+len(p.Attributes))
+		for k, v := range output[i].Attributes {
+			attributes[k] = v
+		}
+		for k, v := range p.Attributes {
+			if attributes[k] == nil {
+				attributes[k] = v
+			}
+		}
+		output[i].Attributes = attributes
+	}
+	return output
+}
+
+//SetMissingProvenance gives p as provenance to the databags of the container that don't have one yet,
+//with the top level attributes of each databag
+func (c *ConfigContainer) SetMissingProvenance(p Provenance) {
+	for typeName, databags := range c.DataBags {
+		for name, group := range databags {
+			var newGroup DataBagGroup
+			for i, bag := range group {
+				if len(bag.Provenance) != 0 {
+					continue
+				}
+				if newGroup == nil {
+					newGroup = append(DataBagGroup{}, group...)
+				}
+				bagProvenance := p
+				bagProvenance.Attributes = map[string]*SourceRange{}
+				if bag.Value.Type == TokenTypeObjectConst {
+					for _, pair := range bag.Value.ObjectConst {
+						bagProvenance.Attributes[pair.Key] = nil
+					}
+				}
+				newGroup[i].Provenance = []Provenance{bagProvenance}
+			}
+			if newGroup != nil {
+				c.DataBags[typeName][name] = newGroup
+			}
+		}
+	}
+}
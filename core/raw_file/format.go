@@ -46,7 +46,7 @@ func applyRawFile(ctx context.Context, databag core.DataBag) error {
 		return errors.New("raw_file databag's syntax token must be of type object")
 	}
 
-	outputDir := ctx.Value("maker").(*core.Maker).OutputDir
+	outputDir := core.MakerFromContext(ctx).OutputDir
 	outputPath := ""
 	content := ""
 	for _, pair := range databag.Value.ObjectConst {
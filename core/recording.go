@@ -0,0 +1,172 @@
+package core
+
+import (
+	"barbe/core/fetcher"
+	"barbe/core/version"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+const CurrentRecordingFormatVersion = 1
+
+//Recording holds everything a run consumed: the input files, the fetched files, the env, the state read from
+//the state stores and the files read back from containers. Replaying it runs the same generation without
+//network access, state stores or containers.
+//Nothing is redacted since the replay needs the same values, a recording holds the secrets of the run in plaintext
+type Recording struct {
+	FormatVersion int
+	BarbeVersion  string
+	Command       MakeCommand
+	InputFiles    []fetcher.FileDescription
+	//requested url -> file returned for it
+	FetchedFiles map[string]fetcher.FileDescription
+	Env          map[string]string
+	//Variables are the values given with --var and --var-file
+	Variables map[string]SyntaxToken `json:",omitempty"`
+	//state store name -> state read from it
+	States map[string]StateHolder
+	//buildkit_run_in_container databag name -> files read back after the run
+	ReadBackFiles map[string][]fetcher.FileDescription
+
+	mutex sync.Mutex
+}
+
+func NewRecording(command MakeCommand, inputFiles []fetcher.FileDescription, env map[string]string) *Recording {
+	return &Recording{
+		FormatVersion: CurrentRecordingFormatVersion,
+		BarbeVersion:  version.Version,
+		Command:       command,
+		InputFiles:    inputFiles,
+		FetchedFiles:  map[string]fetcher.FileDescription{},
+		Env:           env,
+		States:        map[string]StateHolder{},
+		ReadBackFiles: map[string][]fetcher.FileDescription{},
+	}
+}
+
+//ReadRecording reads a recording written by Recording.Write
+func ReadRecording(filePath string) (*Recording, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening recording")
+	}
+	defer f.Close()
+	reader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decompressing recording")
+	}
+	recording := &Recording{}
+	err = json.NewDecoder(reader).Decode(recording)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing recording")
+	}
+	if recording.FormatVersion != CurrentRecordingFormatVersion {
+		return nil, fmt.Errorf("unsupported recording format version %d", recording.FormatVersion)
+	}
+	return recording, nil
+}
+
+//Write saves the recording as gzipped JSON, the fetched files are taken from f
+func (r *Recording) Write(filePath string, f *fetcher.Fetcher) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for requestedUrl, file := range f.FetchedFiles() {
+		r.FetchedFiles[requestedUrl] = file
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling recording")
+	}
+	buf := bytes.Buffer{}
+	writer := gzip.NewWriter(&buf)
+	_, err = writer.Write(b)
+	if err != nil {
+		return errors.Wrap(err, "error compressing recording")
+	}
+	err = writer.Close()
+	if err != nil {
+		return errors.Wrap(err, "error compressing recording")
+	}
+	err = os.WriteFile(filePath, buf.Bytes(), 0600)
+	if err != nil {
+		return errors.Wrap(err, "error writing recording")
+	}
+	return nil
+}
+
+func (r *Recording) RecordReadBackFiles(runName string, files []fetcher.FileDescription) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ReadBackFiles[runName] = files
+}
+
+func (r *Recording) RecordedReadBackFiles(runName string) []fetcher.FileDescription {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ReadBackFiles[runName]
+}
+
+//recordingStatePersister records the state read from a state store
+type recordingStatePersister struct {
+	name      string
+	persister StatePersister
+	recording *Recording
+}
+
+func (p recordingStatePersister) ReadState() (*StateHolder, error) {
+	state, err := p.persister.ReadState()
+	if err != nil || state == nil {
+		return state, err
+	}
+	//the state handler modifies the state it reads, the recording must keep it as it was read
+	recorded, err := copyStateHolder(*state)
+	if err != nil {
+		return nil, err
+	}
+	p.recording.mutex.Lock()
+	defer p.recording.mutex.Unlock()
+	p.recording.States[p.name] = *recorded
+	return state, nil
+}
+
+func (p recordingStatePersister) StoreState(stateHolder StateHolder) error {
+	return p.persister.StoreState(stateHolder)
+}
+
+//replayedStatePersister gives back the state recorded for a state store, and never stores anything
+type replayedStatePersister struct {
+	name      string
+	recording *Recording
+}
+
+func (p replayedStatePersister) ReadState() (*StateHolder, error) {
+	p.recording.mutex.Lock()
+	defer p.recording.mutex.Unlock()
+	state, ok := p.recording.States[p.name]
+	if !ok {
+		return nil, nil
+	}
+	return copyStateHolder(state)
+}
+
+func (p replayedStatePersister) StoreState(stateHolder StateHolder) error {
+	return nil
+}
+
+func copyStateHolder(state StateHolder) (*StateHolder, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, errors.Wrap(err, "error copying state")
+	}
+	output := NewStateHolder()
+	err = json.Unmarshal(b, output)
+	if err != nil {
+		return nil, errors.Wrap(err, "error copying state")
+	}
+	return output, nil
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+//SchemaDatabagType describes the attributes and nested blocks of a databag type, the name of the databag is the described type, ex:
+//`barbe_schema "aws_fargate_task" { attributes = { cpu = { type = "number", required = true } }, blocks = { auto_scaling = { ... } } }`
+const SchemaDatabagType = "barbe_schema"
+
+type SchemaAttributeType = string
+
+const (
+	SchemaAttributeTypeAny    SchemaAttributeType = "any"
+	SchemaAttributeTypeString SchemaAttributeType = "string"
+	SchemaAttributeTypeNumber SchemaAttributeType = "number"
+	SchemaAttributeTypeBool   SchemaAttributeType = "bool"
+	SchemaAttributeTypeList   SchemaAttributeType = "list"
+	SchemaAttributeTypeObject SchemaAttributeType = "object"
+)
+
+var schemaAttributeTypes = []SchemaAttributeType{
+	SchemaAttributeTypeAny,
+	SchemaAttributeTypeString,
+	SchemaAttributeTypeNumber,
+	SchemaAttributeTypeBool,
+	SchemaAttributeTypeList,
+	SchemaAttributeTypeObject,
+}
+
+type AttributeSchema struct {
+	Type     SchemaAttributeType
+	Required bool
+}
+
+//BlockSchema describes a databag, or a block nested in a databag. Nested blocks can be written as blocks or as object attributes
+type BlockSchema struct {
+	Attributes map[string]AttributeSchema
+	Blocks     map[string]BlockSchema
+	//Required is only used for nested blocks
+	Required bool
+	//AllowUnknown accepts attributes and blocks that are not in the schema
+	AllowUnknown bool
+}
+
+//MergeWith adds the attributes and blocks of other, for types several components understand
+func (s BlockSchema) MergeWith(other BlockSchema) BlockSchema {
+	output := BlockSchema{
+		Attributes:   map[string]AttributeSchema{},
+		Blocks:       map[string]BlockSchema{},
+		Required:     s.Required || other.Required,
+		AllowUnknown: s.AllowUnknown || other.AllowUnknown,
+	}
+	for _, schema := range []BlockSchema{s, other} {
+		for name, attr := range schema.Attributes {
+			if existing, ok := output.Attributes[name]; ok && existing.Type != attr.Type {
+				attr.Type = SchemaAttributeTypeAny
+			}
+			output.Attributes[name] = attr
+		}
+		for name, block := range schema.Blocks {
+			if existing, ok := output.Blocks[name]; ok {
+				block = existing.MergeWith(block)
+			}
+			output.Blocks[name] = block
+		}
+	}
+	return output
+}
+
+func interpretBlockSchema(token SyntaxToken) (BlockSchema, error) {
+	schema := BlockSchema{
+		Attributes: map[string]AttributeSchema{},
+		Blocks:     map[string]BlockSchema{},
+	}
+	attrs, err := extractBlockAttrs(token)
+	if err != nil {
+		return schema, err
+	}
+	for _, pair := range attrs {
+		switch pair.Key {
+		case "attributes":
+			if pair.Value.Type != TokenTypeObjectConst {
+				return schema, errors.New("'attributes' must be an object")
+			}
+			for _, attrPair := range pair.Value.ObjectConst {
+				attr, err := interpretAttributeSchema(attrPair.Value)
+				if err != nil {
+					return schema, errors.Wrap(err, "error parsing attribute '"+attrPair.Key+"'")
+				}
+				schema.Attributes[attrPair.Key] = attr
+			}
+		case "blocks":
+			if pair.Value.Type != TokenTypeObjectConst {
+				return schema, errors.New("'blocks' must be an object")
+			}
+			for _, blockPair := range pair.Value.ObjectConst {
+				block, err := interpretBlockSchema(blockPair.Value)
+				if err != nil {
+					return schema, errors.Wrap(err, "error parsing block '"+blockPair.Key+"'")
+				}
+				schema.Blocks[blockPair.Key] = block
+			}
+		case "required":
+			schema.Required, err = ExtractAsBool(pair.Value)
+			if err != nil {
+				return schema, errors.Wrap(err, "error parsing 'required'")
+			}
+		case "allow_unknown":
+			schema.AllowUnknown, err = ExtractAsBool(pair.Value)
+			if err != nil {
+				return schema, errors.Wrap(err, "error parsing 'allow_unknown'")
+			}
+		}
+	}
+	return schema, nil
+}
+
+//interpretAttributeSchema accepts `{ type = "string", required = true }` or just the type
+func interpretAttributeSchema(token SyntaxToken) (AttributeSchema, error) {
+	attr := AttributeSchema{
+		Type: SchemaAttributeTypeAny,
+	}
+	if token.Type != TokenTypeObjectConst {
+		str, err := ExtractAsStringValue(token)
+		if err != nil {
+			return attr, errors.Wrap(err, "attribute schema must be an object or a type")
+		}
+		token = SyntaxToken{
+			Type: TokenTypeObjectConst,
+			ObjectConst: []ObjectConstItem{{
+				Key:   "type",
+				Value: SyntaxToken{Type: TokenTypeLiteralValue, Value: str},
+			}},
+		}
+	}
+	for _, pair := range token.ObjectConst {
+		switch pair.Key {
+		case "type":
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return attr, errors.Wrap(err, "error parsing 'type'")
+			}
+			if !contains(schemaAttributeTypes, str) {
+				return attr, fmt.Errorf("unknown type '%s', must be one of %s", str, strings.Join(schemaAttributeTypes, ", "))
+			}
+			attr.Type = str
+		case "required":
+			required, err := ExtractAsBool(pair.Value)
+			if err != nil {
+				return attr, errors.Wrap(err, "error parsing 'required'")
+			}
+			attr.Required = required
+		}
+	}
+	return attr, nil
+}
+
+//readSchemas returns the schemas found in the container, keyed by the type they describe
+func readSchemas(container ConfigContainer) (map[string]BlockSchema, error) {
+	schemas := map[string]BlockSchema{}
+	for bagType, group := range container.DataBags[SchemaDatabagType] {
+		for _, bag := range group {
+			schema, err := interpretBlockSchema(bag.Value)
+			if err != nil {
+				return nil, errors.Wrap(err, "error parsing '"+SchemaDatabagType+"' block '"+bagType+"'")
+			}
+			if existing, ok := schemas[bagType]; ok {
+				schema = existing.MergeWith(schema)
+			}
+			schemas[bagType] = schema
+		}
+	}
+	return schemas, nil
+}
+
+//ValidateSchemas checks the databags of the container against the barbe_schema databags it contains,
+//the diagnostics are located at the offending attribute when the parser recorded its location
+func ValidateSchemas(container ConfigContainer) (Diagnostics, error) {
+	schemas, err := readSchemas(container)
+	if err != nil {
+		return nil, err
+	}
+	diags := Diagnostics{}
+	for _, bagType := range sortedUnion(mapKeys(schemas), nil) {
+		for _, bagName := range sortedUnion(mapKeys(container.DataBags[bagType]), nil) {
+			for _, bag := range container.DataBags[bagType][bagName] {
+				v := schemaValidator{
+					bagId:      databagId(bagType, bagName, strings.Join(bag.Labels, ".")),
+					provenance: fileProvenance(bag.Provenance),
+				}
+				v.validate(schemas[bagType], bag.Value, nil)
+				diags = append(diags, v.diags...)
+			}
+		}
+	}
+	return diags, nil
+}
+
+func fileProvenance(provenance []Provenance) Provenance {
+	for _, p := range provenance {
+		if p.Kind == ProvenanceFile && p.Range != nil {
+			return p
+		}
+	}
+	for _, p := range provenance {
+		if p.Kind == ProvenanceFile {
+			return p
+		}
+	}
+	return Provenance{}
+}
+
+type schemaValidator struct {
+	bagId      string
+	provenance Provenance
+	diags      Diagnostics
+}
+
+//error locates the diagnostic at key in the block at path, or at the block itself if key is empty
+func (v *schemaValidator) error(path []string, key string, summary string, detail string) {
+	name := v.bagId
+	if len(path) != 0 {
+		name += "." + strings.Join(path, ".")
+	}
+	at := path
+	if key != "" {
+		at = append(append([]string{}, path...), key)
+	}
+	d := Diagnostic{
+		Severity: DiagnosticSeverityError,
+		Summary:  summary + " in " + name,
+		Detail:   detail,
+		Range:    v.provenance.AttributeRange(at),
+	}
+	if d.Range == nil && v.provenance.Source != "" {
+		d.Summary += " (" + v.provenance.Source + ")"
+	}
+	v.diags = append(v.diags, d)
+}
+
+func (v *schemaValidator) validate(schema BlockSchema, token SyntaxToken, path []string) {
+	//values computed from expressions can't be checked before they are evaluated
+	if token.Type != TokenTypeObjectConst {
+		return
+	}
+	seen := map[string]struct{}{}
+	for _, pair := range token.ObjectConst {
+		seen[pair.Key] = struct{}{}
+		if attr, ok := schema.Attributes[pair.Key]; ok {
+			if !tokenMatchesType(pair.Value, attr.Type) {
+				v.error(path, pair.Key, fmt.Sprintf("attribute '%s' must be of type %s", pair.Key, attr.Type), "")
+			}
+			continue
+		}
+		if block, ok := schema.Blocks[pair.Key]; ok {
+			if pair.Value.Type == TokenTypeArrayConst {
+				for i, item := range pair.Value.ArrayConst {
+					itemPath := append(append([]string{}, path...), fmt.Sprintf("%s[%d]", pair.Key, i))
+					v.validate(block, item, itemPath)
+				}
+			} else {
+				v.validate(block, pair.Value, append(append([]string{}, path...), pair.Key))
+			}
+			continue
+		}
+		if schema.AllowUnknown {
+			continue
+		}
+		detail := ""
+		if suggestion := closestName(pair.Key, append(mapKeys(schema.Attributes), mapKeys(schema.Blocks)...)); suggestion != "" {
+			detail = "did you mean '" + suggestion + "'?"
+		}
+		v.error(path, pair.Key, "unsupported attribute '"+pair.Key+"'", detail)
+	}
+	for _, name := range sortedUnion(mapKeys(schema.Attributes), mapKeys(schema.Blocks)) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		if schema.Attributes[name].Required || schema.Blocks[name].Required {
+			v.error(path, "", "missing required attribute '"+name+"'", "")
+		}
+	}
+}
+
+func tokenMatchesType(token SyntaxToken, attrType SchemaAttributeType) bool {
+	if attrType == SchemaAttributeTypeAny {
+		return true
+	}
+	switch token.Type {
+	case TokenTypeLiteralValue:
+		switch token.Value.(type) {
+		case nil:
+			return true
+		case string:
+			return attrType == SchemaAttributeTypeString
+		case bool:
+			return attrType == SchemaAttributeTypeBool
+		case float64, float32, int, int64, int32, uint, uint64, uint32:
+			return attrType == SchemaAttributeTypeNumber
+		}
+		return true
+	case TokenTypeTemplate:
+		return attrType == SchemaAttributeTypeString
+	case TokenTypeArrayConst:
+		return attrType == SchemaAttributeTypeList
+	case TokenTypeObjectConst:
+		return attrType == SchemaAttributeTypeObject
+	default:
+		return true
+	}
+}
+
+//closestName returns the candidate with the smallest edit distance to name, if it's close enough to be a typo
+func closestName(name string, candidates []string) string {
+	sort.Strings(candidates)
+	best := ""
+	bestDistance := len(name)/3 + 1
+	if bestDistance < 3 {
+		bestDistance = 3
+	}
+	for _, candidate := range candidates {
+		d := levenshtein(name, candidate)
+		if d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
@@ -0,0 +1,194 @@
+package secret_provider
+
+import (
+	"barbe/core"
+	"bytes"
+	"context"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//EnvProvider reads the secret from an env var of the barbe process, it doesn't need to be exposed with --env
+type EnvProvider struct{}
+
+func (p EnvProvider) GetSecret(ctx context.Context, request SecretRequest) ([]byte, error) {
+	if request.Name == "" {
+		return nil, errors.New("the env secret provider needs a name")
+	}
+	value, ok := os.LookupEnv(request.Name)
+	if !ok {
+		return nil, fmt.Errorf("env var '%s' is not set", request.Name)
+	}
+	return []byte(value), nil
+}
+
+type FileProvider struct{}
+
+func (p FileProvider) GetSecret(ctx context.Context, request SecretRequest) ([]byte, error) {
+	return readSecretFile(ctx, request)
+}
+
+//AgeProvider decrypts a file encrypted with age, binary or armored. The identity comes from identity_file,
+//which must be in the project directory like path, or from the same places sops looks for it:
+//SOPS_AGE_KEY_FILE then ~/.config/sops/age/keys.txt
+type AgeProvider struct{}
+
+func (p AgeProvider) GetSecret(ctx context.Context, request SecretRequest) ([]byte, error) {
+	content, err := readSecretFile(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var identityFile string
+	if request.IdentityFile != "" {
+		identityFile, err = projectPath(ctx, request.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		identityFile = os.Getenv("SOPS_AGE_KEY_FILE")
+		if identityFile == "" {
+			identityFile = "~/.config/sops/age/keys.txt"
+		}
+		identityFile, err = homedir.Expand(identityFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error expanding identity file path")
+		}
+	}
+	f, err := os.Open(identityFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening age identity file")
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing age identity file '"+identityFile+"'")
+	}
+
+	var reader io.Reader = bytes.NewReader(content)
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)) {
+		reader = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	}
+	decrypted, err := age.Decrypt(reader, identities...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting '"+request.Path+"'")
+	}
+	output, err := io.ReadAll(decrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting '"+request.Path+"'")
+	}
+	return output, nil
+}
+
+//SopsProvider decrypts a file with the sops executable, which must be in the PATH and finds the keys on its own.
+//The decrypted document is given as JSON so key can select a value in it whatever the format of the file
+type SopsProvider struct{}
+
+func (p SopsProvider) GetSecret(ctx context.Context, request SecretRequest) ([]byte, error) {
+	if request.Path == "" {
+		return nil, errors.New("the sops secret provider needs a path")
+	}
+	filePath, err := projectPath(ctx, request.Path)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--decrypt"}
+	if request.Key != "" {
+		args = append(args, "--output-type", "json")
+	}
+	args = append(args, filePath)
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "sops", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting '"+request.Path+"' with sops: "+strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+//HttpProvider GETs the secret from an url, like a secret store's HTTP API
+type HttpProvider struct{}
+
+func (p HttpProvider) GetSecret(ctx context.Context, request SecretRequest) ([]byte, error) {
+	if request.Url == "" {
+		return nil, errors.New("the http secret provider needs an url")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, request.Url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error requesting secret")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		//the body is left out, it could echo the credentials of the request
+		return nil, fmt.Errorf("secret store responded with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading secret store response")
+	}
+	return body, nil
+}
+
+func readSecretFile(ctx context.Context, request SecretRequest) ([]byte, error) {
+	if request.Path == "" {
+		return nil, fmt.Errorf("the %s secret provider needs a path", request.Provider)
+	}
+	filePath, err := projectPath(ctx, request.Path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading secret file")
+	}
+	return content, nil
+}
+
+//projectPath resolves p against the project directory (the BaseDir of the maker, or the working directory)
+//and refuses the paths that lead outside of it, symlinks included. `~` isn't expanded
+func projectPath(ctx context.Context, p string) (string, error) {
+	baseDir := ""
+	if maker := core.MakerFromContext(ctx); maker != nil {
+		baseDir = maker.BaseDir
+	}
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting absolute path of the project directory")
+	}
+	filePath := p
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(baseDir, filePath)
+	}
+	realBaseDir, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return "", errors.Wrap(err, "error resolving project directory")
+	}
+	realPath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, "error resolving path '"+p+"'")
+	}
+	rel, err := filepath.Rel(realBaseDir, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside of the project directory '%s'", p, baseDir)
+	}
+	return realPath, nil
+}
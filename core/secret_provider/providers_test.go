@@ -0,0 +1,101 @@
+package secret_provider
+
+import (
+	"barbe/core"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHttpProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected a GET request, got %s", r.Method)
+		}
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"password":"hunter2"}}`))
+	}))
+	defer server.Close()
+
+	transformer := NewSecretProviderTransformer()
+	value, err := transformer.resolve(context.Background(), SecretRequest{
+		Provider: "http",
+		Url:      server.URL,
+		Headers:  map[string]string{"X-Vault-Token": "token"},
+		Key:      "data.password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "hunter2" {
+		t.Errorf("expected 'hunter2', got '%s'", value)
+	}
+}
+
+func TestHttpProviderErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("token 'secret-token' is invalid"))
+	}))
+	defer server.Close()
+
+	_, err := HttpProvider{}.GetSecret(context.Background(), SecretRequest{
+		Provider: "http",
+		Url:      server.URL,
+		Headers:  map[string]string{"X-Vault-Token": "secret-token"},
+	})
+	if err == nil {
+		t.Fatal("expected an error on a 403 response")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("the error must not contain the response body: %s", err)
+	}
+}
+
+func TestFileProviderStaysInProject(t *testing.T) {
+	projectDir := t.TempDir()
+	outsideDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(projectDir, "password"), []byte("hunter2\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(outsideDir, "password"), []byte("outside"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(outsideDir, "password"), filepath.Join(projectDir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maker := core.NewMaker(core.MakeCommandGenerate, nil)
+	maker.BaseDir = projectDir
+	ctx := core.ContextWithMaker(context.Background(), maker)
+
+	content, err := FileProvider{}.GetSecret(ctx, SecretRequest{Provider: "file", Path: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hunter2\n" {
+		t.Errorf("expected 'hunter2\\n', got '%s'", content)
+	}
+
+	for _, p := range []string{
+		filepath.Join(outsideDir, "password"),
+		"../" + filepath.Base(outsideDir) + "/password",
+		"link",
+		"~/.ssh/id_rsa",
+	} {
+		_, err := FileProvider{}.GetSecret(ctx, SecretRequest{Provider: "file", Path: p})
+		if err == nil {
+			t.Errorf("expected reading '%s' to fail", p)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package secret_provider
+
+import (
+	"barbe/core"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//SecretRequest is the content of a secret_request databag
+type SecretRequest struct {
+	Provider string
+	//Name is the env var read by the env provider
+	Name string
+	//Path is the file read by the file, age and sops providers
+	Path string
+	//IdentityFile is the age identity used by the age provider
+	IdentityFile string
+	//Url and Headers are used by the http provider
+	Url     string
+	Headers map[string]string
+	//Key is a dot separated path selecting a value in the secret, which must then be a JSON document
+	Key      string
+	Optional bool
+}
+
+//SecretProvider returns the content of a secret, Key is applied on it by the transformer
+type SecretProvider interface {
+	GetSecret(ctx context.Context, request SecretRequest) ([]byte, error)
+}
+
+//SecretProviderTransformer resolves the secret_request databags into secret databags holding a sensitive value
+type SecretProviderTransformer struct {
+	//Providers are looked up by the provider attribute of the request
+	Providers map[string]SecretProvider
+
+	mutex sync.Mutex
+	//request json -> resolved value, the same secret is requested on every transformation
+	cache map[string]string
+}
+
+func NewSecretProviderTransformer() *SecretProviderTransformer {
+	return &SecretProviderTransformer{
+		Providers: map[string]SecretProvider{
+			"env":  EnvProvider{},
+			"file": FileProvider{},
+			"age":  AgeProvider{},
+			"sops": SopsProvider{},
+			"http": HttpProvider{},
+		},
+		cache: map[string]string{},
+	}
+}
+
+func (t *SecretProviderTransformer) Name() string {
+	return "secret_provider"
+}
+
+//Transform only resolves the requests declared in the input files, the components (even remote ones) and
+//the files they reference could otherwise read any env var or local file, or send requests with any header
+func (t *SecretProviderTransformer) Transform(ctx context.Context, data core.ConfigContainer) (core.ConfigContainer, error) {
+	maker := core.MakerFromContext(ctx)
+	output := core.NewConfigContainer()
+	for resourceType, m := range data.DataBags {
+		if resourceType != "secret_request" {
+			continue
+		}
+		for _, group := range m {
+			for _, databag := range group {
+				if databag.Value.Type != core.TokenTypeObjectConst {
+					continue
+				}
+				existing := data.GetDataBagGroup("secret", databag.Name)
+				if len(existing) > 0 {
+					continue
+				}
+				if !fromInputFiles(maker, databag) {
+					return core.ConfigContainer{}, fmt.Errorf("secret_request '%s' is not declared in an input file (declared by %s), only the input files can request secrets", databag.Name, provenanceList(databag))
+				}
+				request, err := parseSecretRequest(ctx, databag.Value.ObjectConst)
+				if err != nil {
+					return core.ConfigContainer{}, errors.Wrap(err, "error parsing secret_request '"+databag.Name+"'")
+				}
+				value, err := t.resolve(ctx, request)
+				if err != nil {
+					if request.Optional {
+						log.Ctx(ctx).Debug().Err(err).Msgf("optional secret '%s' not found", databag.Name)
+						continue
+					}
+					return core.ConfigContainer{}, errors.Wrap(err, "error getting secret '"+databag.Name+"'")
+				}
+				err = output.Insert(core.DataBag{
+					Name:   databag.Name,
+					Type:   "secret",
+					Labels: databag.Labels,
+					Value: core.SyntaxToken{
+						Type: core.TokenTypeObjectConst,
+						ObjectConst: []core.ObjectConstItem{
+							{
+								Key: "value",
+								Value: core.MarkSensitive(core.SyntaxToken{
+									Type:  core.TokenTypeLiteralValue,
+									Value: value,
+								}),
+							},
+						},
+					},
+				})
+				if err != nil {
+					return core.ConfigContainer{}, errors.Wrap(err, "error inserting secret")
+				}
+			}
+		}
+	}
+	return *output, nil
+}
+
+//fromInputFiles tells if every contributor of the databag is a file given by the user
+func fromInputFiles(maker *core.Maker, databag core.DataBag) bool {
+	if maker == nil || len(databag.Provenance) == 0 {
+		return false
+	}
+	for _, p := range databag.Provenance {
+		if p.Kind != core.ProvenanceFile || !maker.IsInputFile(p.Source) {
+			return false
+		}
+	}
+	return true
+}
+
+func provenanceList(databag core.DataBag) string {
+	if len(databag.Provenance) == 0 {
+		return "an unknown source"
+	}
+	strs := make([]string, 0, len(databag.Provenance))
+	for _, p := range databag.Provenance {
+		strs = append(strs, p.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (t *SecretProviderTransformer) resolve(ctx context.Context, request SecretRequest) (string, error) {
+	cacheKey, err := json.Marshal(request)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling secret request")
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if value, ok := t.cache[string(cacheKey)]; ok {
+		return value, nil
+	}
+
+	provider, ok := t.Providers[request.Provider]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider '%s'", request.Provider)
+	}
+	content, err := provider.GetSecret(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(content), "\r\n")
+	if request.Key != "" {
+		value, err = extractKey(content, request.Key)
+		if err != nil {
+			return "", err
+		}
+	}
+	t.cache[string(cacheKey)] = value
+	return value, nil
+}
+
+func parseSecretRequest(ctx context.Context, objConst []core.ObjectConstItem) (SecretRequest, error) {
+	request := SecretRequest{
+		Headers: map[string]string{},
+	}
+	strFields := map[string]*string{
+		"provider":      &request.Provider,
+		"name":          &request.Name,
+		"path":          &request.Path,
+		"identity_file": &request.IdentityFile,
+		"url":           &request.Url,
+		"key":           &request.Key,
+	}
+	for key, field := range strFields {
+		tokens := core.GetObjectKeyValues(key, objConst)
+		if len(tokens) == 0 {
+			continue
+		}
+		if len(tokens) > 1 {
+			log.Ctx(ctx).Warn().Msgf("multiple %s found on secret_request, using the first one", key)
+		}
+		str, err := core.ExtractAsStringValue(tokens[0])
+		if err != nil {
+			return SecretRequest{}, errors.Wrap(err, "error extracting "+key+" value as string on secret_request")
+		}
+		*field = str
+	}
+	if request.Provider == "" {
+		return SecretRequest{}, errors.New("secret_request must have a provider")
+	}
+
+	optionalTokens := core.GetObjectKeyValues("optional", objConst)
+	if len(optionalTokens) > 0 {
+		optional, err := core.ExtractAsBool(optionalTokens[0])
+		if err != nil {
+			return SecretRequest{}, errors.Wrap(err, "error extracting optional value as bool on secret_request")
+		}
+		request.Optional = optional
+	}
+
+	for _, headersToken := range core.GetObjectKeyValues("headers", objConst) {
+		if headersToken.Type != core.TokenTypeObjectConst {
+			return SecretRequest{}, errors.New("headers on secret_request must be an object")
+		}
+		for _, pair := range headersToken.ObjectConst {
+			value, err := core.ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return SecretRequest{}, errors.Wrap(err, "error extracting header '"+pair.Key+"' value as string on secret_request")
+			}
+			request.Headers[pair.Key] = value
+		}
+	}
+	return request, nil
+}
+
+//extractKey parses content as JSON and returns the value at the dot separated path key,
+//values that aren't strings are returned as JSON
+func extractKey(content []byte, key string) (string, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing secret as JSON to extract key '"+key+"'")
+	}
+	for _, part := range strings.Split(key, ".") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			item, ok := v[part]
+			if !ok {
+				return "", fmt.Errorf("key '%s' not found in secret", key)
+			}
+			doc = item
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(v) {
+				return "", fmt.Errorf("key '%s' not found in secret", key)
+			}
+			doc = v[index]
+		default:
+			return "", fmt.Errorf("key '%s' not found in secret", key)
+		}
+	}
+	if str, ok := doc.(string); ok {
+		return str, nil
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling value of key '"+key+"'")
+	}
+	return string(b), nil
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	//MetaKeySensitive marks a token, and everything in it, as holding a secret
+	MetaKeySensitive = "sensitive"
+	//SensitivePlaceholder replaces the sensitive values in logs, debug bags and traces
+	SensitivePlaceholder = "<sensitive>"
+	//shorter strings are not redacted from the logs, it would make them unreadable for little benefit
+	minRedactedLength = 4
+)
+
+//MarkSensitive returns the token marked as sensitive, the Meta map is copied so other holders of the token are untouched
+func MarkSensitive(token SyntaxToken) SyntaxToken {
+	meta := make(map[string]interface{}, len(token.Meta)+1)
+	for k, v := range token.Meta {
+		meta[k] = v
+	}
+	meta[MetaKeySensitive] = true
+	token.Meta = meta
+	return token
+}
+
+func IsSensitive(token SyntaxToken) bool {
+	return GetMetaBool(token, MetaKeySensitive)
+}
+
+//ContainsSensitive is true if the token or any token in it is sensitive
+func ContainsSensitive(token SyntaxToken) bool {
+	if IsSensitive(token) {
+		return true
+	}
+	for _, child := range tokenChildren(token) {
+		if ContainsSensitive(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConfigContainer) ContainsSensitive() bool {
+	for _, databags := range c.DataBags {
+		for _, group := range databags {
+			for _, bag := range group {
+				if ContainsSensitive(bag.Value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+//RedactSensitive returns a copy of the token where every sensitive token is replaced by SensitivePlaceholder
+func RedactSensitive(token SyntaxToken) (SyntaxToken, error) {
+	if !ContainsSensitive(token) {
+		return token, nil
+	}
+	//going through the generic json form copies the token and reaches every nested token whatever its type
+	b, err := json.Marshal(token)
+	if err != nil {
+		return SyntaxToken{}, errors.Wrap(err, "error marshalling token to redact")
+	}
+	var generic interface{}
+	err = json.Unmarshal(b, &generic)
+	if err != nil {
+		return SyntaxToken{}, errors.Wrap(err, "error unmarshalling token to redact")
+	}
+	b, err = json.Marshal(redactGeneric(generic))
+	if err != nil {
+		return SyntaxToken{}, errors.Wrap(err, "error marshalling redacted token")
+	}
+	output := SyntaxToken{}
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		return SyntaxToken{}, errors.Wrap(err, "error unmarshalling redacted token")
+	}
+	return output, nil
+}
+
+func redactGeneric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if meta, ok := v["Meta"].(map[string]interface{}); ok && meta[MetaKeySensitive] == true {
+			return map[string]interface{}{
+				"Type":  TokenTypeLiteralValue,
+				"Value": SensitivePlaceholder,
+				"Meta":  meta,
+			}
+		}
+		for k, item := range v {
+			v[k] = redactGeneric(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactGeneric(item)
+		}
+	}
+	return v
+}
+
+//Redacted returns the container itself if it holds no sensitive value, otherwise a copy of it with the sensitive values redacted
+func (c *ConfigContainer) Redacted() (*ConfigContainer, error) {
+	if !c.ContainsSensitive() {
+		return c, nil
+	}
+	clone := c.Clone()
+	for typeName, databags := range clone.DataBags {
+		for name, group := range databags {
+			for i, bag := range group {
+				redacted, err := RedactSensitive(bag.Value)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("error redacting databag '%s.%s'", typeName, name))
+				}
+				clone.DataBags[typeName][name][i].Value = redacted
+			}
+		}
+	}
+	return clone, nil
+}
+
+//SensitiveStrings returns the string values of the sensitive tokens in token
+func SensitiveStrings(token SyntaxToken) []string {
+	output := make([]string, 0)
+	if IsSensitive(token) {
+		collectStrings(token, &output)
+		return output
+	}
+	for _, child := range tokenChildren(token) {
+		output = append(output, SensitiveStrings(child)...)
+	}
+	return output
+}
+
+func collectStrings(token SyntaxToken, output *[]string) {
+	if str, ok := token.Value.(string); ok && token.Type == TokenTypeLiteralValue {
+		*output = append(*output, str)
+	}
+	for _, child := range tokenChildren(token) {
+		collectStrings(child, output)
+	}
+}
+
+func tokenChildren(token SyntaxToken) []SyntaxToken {
+	output := make([]SyntaxToken, 0, len(token.ObjectConst)+len(token.ArrayConst)+len(token.FunctionArgs)+len(token.Parts))
+	for _, pair := range token.ObjectConst {
+		output = append(output, pair.Value)
+	}
+	output = append(output, token.ArrayConst...)
+	output = append(output, token.FunctionArgs...)
+	output = append(output, token.Parts...)
+	pointers := []*SyntaxToken{
+		token.IndexCollection, token.IndexKey, token.Source,
+		token.ForCollExpr, token.ForKeyExpr, token.ForValExpr, token.ForCondExpr,
+		token.Condition, token.TrueResult, token.FalseResult,
+		token.RightHandSide, token.LeftHandSide, token.SplatEach,
+	}
+	for _, ptr := range pointers {
+		if ptr != nil {
+			output = append(output, *ptr)
+		}
+	}
+	return output
+}
+
+//sensitiveRegistry holds the sensitive strings seen during a run, to hide them from text that can't carry
+//the sensitive mark, like the logs of containers
+type sensitiveRegistry struct {
+	mutex    sync.Mutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+//RegisterSensitiveValues remembers the sensitive strings of the container, so RedactString hides them
+func (maker *Maker) RegisterSensitiveValues(container ConfigContainer) {
+	found := make([]string, 0)
+	for _, databags := range container.DataBags {
+		for _, group := range databags {
+			for _, bag := range group {
+				found = append(found, SensitiveStrings(bag.Value)...)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	maker.sensitive.mutex.Lock()
+	defer maker.sensitive.mutex.Unlock()
+	if maker.sensitive.values == nil {
+		maker.sensitive.values = map[string]struct{}{}
+	}
+	for _, str := range found {
+		if len(str) < minRedactedLength {
+			continue
+		}
+		if _, ok := maker.sensitive.values[str]; !ok {
+			maker.sensitive.values[str] = struct{}{}
+			maker.sensitive.replacer = nil
+		}
+	}
+}
+
+//RedactString replaces the sensitive strings registered so far by SensitivePlaceholder
+func (maker *Maker) RedactString(s string) string {
+	maker.sensitive.mutex.Lock()
+	defer maker.sensitive.mutex.Unlock()
+	if len(maker.sensitive.values) == 0 {
+		return s
+	}
+	if maker.sensitive.replacer == nil {
+		values := mapKeys(maker.sensitive.values)
+		//longest first, so a secret containing another one is replaced whole
+		sort.Slice(values, func(i, j int) bool {
+			return len(values[i]) > len(values[j])
+		})
+		oldnew := make([]string, 0, len(values)*2)
+		for _, v := range values {
+			oldnew = append(oldnew, v, SensitivePlaceholder)
+		}
+		maker.sensitive.replacer = strings.NewReplacer(oldnew...)
+	}
+	return maker.sensitive.replacer.Replace(s)
+}
@@ -104,6 +104,10 @@ type StateHandler struct {
 	stateMutex               sync.RWMutex
 	alreadyCreatedPersisters map[string]struct{}
 	persisters               []StatePersister
+
+	//IgnoreStateStores makes the state_store databags be dropped without creating their persister,
+	//only the memory persister is used
+	IgnoreStateStores bool
 }
 
 type StateScope struct {
@@ -162,6 +166,58 @@ func (s *StateHandler) GetState(scopeKey string) map[string]any {
 	return s.currentState.States[scopeKey]
 }
 
+//CurrentState returns a copy of the state, scope by scope. The values themselves are not copied
+func (s *StateHandler) CurrentState() StateHolder {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	output := *NewStateHolder()
+	if s.currentState == nil {
+		return output
+	}
+	output.FormatVersion = s.currentState.FormatVersion
+	for scopeKey, state := range s.currentState.States {
+		output.States[scopeKey] = make(map[string]any, len(state))
+		for k, v := range state {
+			output.States[scopeKey][k] = v
+		}
+	}
+	return output
+}
+
+//ReplaceState overrides the whole state, it still needs to be persisted
+func (s *StateHandler) ReplaceState(stateHolder StateHolder) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if stateHolder.States == nil {
+		stateHolder.States = make(map[string]map[string]any)
+	}
+	s.currentState = &stateHolder
+}
+
+//MoveScope renames a scope key, for example when the url of a component changed
+func (s *StateHandler) MoveScope(fromScopeKey string, toScopeKey string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if s.currentState == nil || s.currentState.States[fromScopeKey] == nil {
+		return errors.New("scope '" + fromScopeKey + "' not found in state")
+	}
+	if _, ok := s.currentState.States[toScopeKey]; ok {
+		return errors.New("scope '" + toScopeKey + "' already exists in state")
+	}
+	s.currentState.States[toScopeKey] = s.currentState.States[fromScopeKey]
+	delete(s.currentState.States, fromScopeKey)
+	return nil
+}
+
+func (s *StateHandler) DeleteScope(scopeKey string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if s.currentState == nil || s.currentState.States == nil {
+		return
+	}
+	delete(s.currentState.States, scopeKey)
+}
+
 func (s *StateHandler) HandleStateDatabags(ctx context.Context, container *ConfigContainer) error {
 	err := s.CreatePersisters(ctx, container)
 	if err != nil {
@@ -179,7 +235,7 @@ func (s *StateHandler) HandleStateDatabags(ctx context.Context, container *Confi
 
 func (s *StateHandler) CreatePersisters(ctx context.Context, container *ConfigContainer) error {
 	group := container.GetDataBagsOfType(StateStoreDatabagType)
-	if len(group) == 0 {
+	if len(group) == 0 || s.IgnoreStateStores {
 		return nil
 	}
 	for _, bag := range group {
@@ -187,11 +243,20 @@ func (s *StateHandler) CreatePersisters(ctx context.Context, container *ConfigCo
 			continue
 		}
 		s.alreadyCreatedPersisters[bag.Name] = struct{}{}
-		persister, err := NewStatePersister(ctx, s.Maker, bag.Name, bag.Value)
-		if err != nil {
-			return errors.Wrap(err, "error creating state persister '"+bag.Name+"' of type")
+		var persister StatePersister
+		if s.Maker.Replaying != nil {
+			persister = replayedStatePersister{name: bag.Name, recording: s.Maker.Replaying}
+		} else {
+			var err error
+			persister, err = NewStatePersister(ctx, s.Maker, bag.Name, bag.Value)
+			if err != nil {
+				return errors.Wrap(err, "error creating state persister '"+bag.Name+"' of type")
+			}
+			if s.Maker.Recording != nil {
+				persister = recordingStatePersister{name: bag.Name, persister: persister, recording: s.Maker.Recording}
+			}
 		}
-		err = s.AddPersister(persister)
+		err := s.AddPersister(persister)
 		if err != nil {
 			return err
 		}
@@ -208,7 +273,7 @@ func (s *StateHandler) AddPersister(newPersister StatePersister) (e error) {
 	if newState == nil {
 		s.stateMutex.Lock()
 		defer s.stateMutex.Unlock()
-		if s.currentState != nil {
+		if s.currentState != nil && !s.Maker.DryRun {
 			err = newPersister.StoreState(*s.currentState)
 			if err != nil {
 				return errors.Wrap(err, "error storing state to new persister")
@@ -221,7 +286,7 @@ func (s *StateHandler) AddPersister(newPersister StatePersister) (e error) {
 	defer s.stateMutex.Unlock()
 
 	defer func() {
-		if e != nil {
+		if e != nil || s.Maker.DryRun {
 			return
 		}
 		eg := errgroup.Group{}
@@ -261,7 +326,7 @@ func (s *StateHandler) AddPersister(newPersister StatePersister) (e error) {
 func (s *StateHandler) Persist() error {
 	s.stateMutex.RLock()
 	defer s.stateMutex.RUnlock()
-	if s.currentState == nil {
+	if s.currentState == nil || s.Maker.DryRun {
 		return nil
 	}
 
@@ -5,11 +5,16 @@ import (
 	"time"
 )
 
-var GlobalState = StateDisplay{
-	mutex:                 &sync.Mutex{},
-	majorStepIndex:        map[string]int{},
-	minorStepIndex:        map[string]map[string]int{},
-	OnStateDisplayChanged: func(stateDisplay StateDisplay) {},
+//GlobalState is the state displayed by the CLI
+var GlobalState = *NewStateDisplay()
+
+func NewStateDisplay() *StateDisplay {
+	return &StateDisplay{
+		mutex:                 &sync.Mutex{},
+		majorStepIndex:        map[string]int{},
+		minorStepIndex:        map[string]map[string]int{},
+		OnStateDisplayChanged: func(stateDisplay StateDisplay) {},
+	}
 }
 
 type StateDisplay struct {
@@ -49,6 +54,19 @@ type MinorStep struct {
 	LogLines  []string
 }
 
+//Reset clears all the steps and logs, for when the same process executes several runs
+func (s *StateDisplay) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.majorStepIndex = map[string]int{}
+	s.minorStepIndex = map[string]map[string]int{}
+	s.MajorsSteps = nil
+	s.Logs = nil
+	if s.OnStateDisplayChanged != nil {
+		s.OnStateDisplayChanged(*s)
+	}
+}
+
 func (s *StateDisplay) StartMajorStep(name string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -10,11 +10,16 @@ import (
 )
 
 type Manifest struct {
+	//Name is the name of the manifest file once fetched
+	Name    string `json:"-"`
 	Message string `json:"message"`
 	//files are plain config files that are added to the files to parse
 	Files      []string `json:"files"`
 	Components []string `json:"components"`
-	Manifests  []string `json:"manifests"`
+	//Manifests carry the params given to each nested manifest
+	Manifests []TemplateEntry `json:"manifests"`
+	//Declarations are keyed by component url, as written in the manifest
+	Declarations map[string]ComponentDeclaration `json:"-"`
 }
 
 func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer) (Executable, error) {
@@ -23,7 +28,7 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 		return Executable{}, nil
 	}
 
-	templateBlock, err := parseTemplateBlock(templateConfig)
+	templateBlock, err := parseTemplateBlock(templateConfig, maker.templateScope(nil), maker.AllTemplateEntries)
 	if err != nil {
 		return Executable{}, errors.Wrap(err, "error parsing template block")
 	}
@@ -34,6 +39,7 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 		if err != nil {
 			return Executable{}, errors.Wrap(err, "error fetching manifest")
 		}
+		maker.Graph.AddEdge(GraphNodeTemplate, "template", GraphNodeManifest, manifest.Name, GraphEdgeIncludes, "")
 		manifests = append(manifests, manifest)
 	}
 
@@ -46,11 +52,12 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 			}
 			noneFound = false
 			for _, link := range manifest.Manifests {
-				manifest, err := maker.fetchManifest(ctx, link)
+				nested, err := maker.fetchManifest(ctx, link)
 				if err != nil {
 					return Executable{}, errors.Wrap(err, "error fetching manifest")
 				}
-				manifests = append(manifests, manifest)
+				maker.Graph.AddEdge(GraphNodeManifest, manifest.Name, GraphNodeManifest, nested.Name, GraphEdgeIncludes, "")
+				manifests = append(manifests, nested)
 			}
 			manifests[i].Manifests = nil
 		}
@@ -84,9 +91,12 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 	}
 
 	executable := Executable{
-		Message:    "",
-		Files:      []fetcher.FileDescription{},
-		Components: []fetcher.FileDescription{},
+		Message:      "",
+		Files:        []fetcher.FileDescription{},
+		Components:   []fetcher.FileDescription{},
+		Declarations: map[string]ComponentDeclaration{},
+		Steps:        templateBlock.Steps,
+		Plugins:      templateBlock.Plugins,
 	}
 	for _, manifest := range manifests {
 		manifest.Message = strings.TrimSpace(manifest.Message)
@@ -101,6 +111,7 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 			if err != nil {
 				return Executable{}, errors.Wrap(err, "error fetching file")
 			}
+			maker.Graph.AddEdge(GraphNodeManifest, manifest.Name, GraphNodeFile, fileDesc.Name, GraphEdgeIncludes, "")
 			executable.Files = append(executable.Files, fileDesc)
 		}
 		for _, component := range manifest.Components {
@@ -108,28 +119,53 @@ func (maker *Maker) GetTemplates(ctx context.Context, container *ConfigContainer
 			if err != nil {
 				return Executable{}, errors.Wrap(err, "error fetching component")
 			}
+			maker.Graph.AddEdge(GraphNodeManifest, manifest.Name, GraphNodeComponent, componentDesc.Name, GraphEdgeIncludes, "")
 			executable.Components = append(executable.Components, componentDesc)
+			if declaration, ok := manifest.Declarations[component]; ok {
+				executable.Declarations[componentDesc.Name] = declaration
+				maker.Graph.AddDeclaration(componentDesc.Name, declaration)
+			}
 		}
 	}
 	return executable, nil
 }
 
-func (maker *Maker) fetchManifest(ctx context.Context, manifestUrl string) (Manifest, error) {
-	manifestFile, err := maker.Fetcher.Fetch(manifestUrl)
+//templateScope is what the entries of the template block and manifests can reference: env.<NAME> (the env exposed
+//to the components), command (generate, apply or destroy) and, in a manifest, the params.<name> it was given
+func (maker *Maker) templateScope(params map[string]interface{}) map[string]interface{} {
+	env := make(map[string]interface{}, len(maker.Env))
+	for k, v := range maker.Env {
+		env[k] = v
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"env":     env,
+		"command": maker.Command,
+		"params":  params,
+	}
+}
+
+func (maker *Maker) fetchManifest(ctx context.Context, link TemplateEntry) (Manifest, error) {
+	manifestFile, err := maker.Fetcher.Fetch(link.Url)
 	if err != nil {
 		return Manifest{}, errors.Wrap(err, "error fetching manifest")
 	}
 
 	container := NewConfigContainer()
-	err = maker.ParseFiles(ctx, []fetcher.FileDescription{manifestFile}, container)
+	err = maker.parseFiles(ctx, []fetcher.FileDescription{manifestFile}, container, false)
 	if err != nil {
 		return Manifest{}, errors.Wrap(err, "error parsing manifest")
 	}
 
-	manifest := Manifest{}
-	message := container.GetDataBagGroup("message", "")
+	manifest := Manifest{
+		Name: manifestFile.Name,
+	}
+	scope := maker.templateScope(link.Params)
+	message := manifestValues(container, "message")
 	for i, msg := range message {
-		str, err := ExtractAsStringValue(msg.Value)
+		str, err := evaluateAsString(msg.Value, scope)
 		if err != nil {
 			log.Ctx(ctx).Warn().Err(err).Msgf("error extracting 'messages[%d]' from manifest", i)
 			continue
@@ -142,34 +178,56 @@ func (maker *Maker) fetchManifest(ctx context.Context, manifestUrl string) (Mani
 		}
 	}
 
-	files := container.GetDataBagGroup("files", "")
+	files := manifestValues(container, "files")
 	for i, file := range files {
-		str, err := interpretAsStrArray(file.Value)
+		entries, err := interpretTemplateEntries(file.Value, scope, maker.AllTemplateEntries)
 		if err != nil {
 			log.Ctx(ctx).Warn().Err(err).Msgf("error extracting 'files[%d]' from manifest", i)
 			continue
 		}
-		manifest.Files = append(manifest.Files, str...)
+		for _, entry := range entries {
+			manifest.Files = append(manifest.Files, entry.Url)
+		}
 	}
 
-	components := container.GetDataBagGroup("components", "")
+	manifest.Declarations = map[string]ComponentDeclaration{}
+	components := manifestValues(container, "components")
 	for i, component := range components {
-		str, err := interpretAsStrArray(component.Value)
+		str, declarations, err := interpretComponentEntries(component.Value, scope, maker.AllTemplateEntries)
 		if err != nil {
 			log.Ctx(ctx).Warn().Err(err).Msgf("error extracting 'components[%d]' from manifest", i)
 			continue
 		}
 		manifest.Components = append(manifest.Components, str...)
+		for url, declaration := range declarations {
+			manifest.Declarations[url] = declaration
+			manifest.Files = append(manifest.Files, declaration.Schemas...)
+		}
 	}
 
-	manifests := container.GetDataBagGroup("manifests", "")
+	manifests := manifestValues(container, "manifests")
 	for i, m := range manifests {
-		str, err := interpretAsStrArray(m.Value)
+		entries, err := interpretTemplateEntries(m.Value, scope, maker.AllTemplateEntries)
 		if err != nil {
 			log.Ctx(ctx).Warn().Err(err).Msgf("error extracting 'manifests[%d]' from manifest", i)
 			continue
 		}
-		manifest.Manifests = append(manifest.Components, str...)
+		manifest.Manifests = append(manifest.Manifests, entries...)
 	}
 	return manifest, nil
 }
+
+//manifestValues returns the databags of type key, JSON manifests are parsed that way,
+//along with the top level attribute key of HCL manifests
+func manifestValues(container *ConfigContainer, key string) []DataBag {
+	output := append([]DataBag{}, container.GetDataBagGroup(key, "")...)
+	for _, root := range container.GetDataBagGroup("", "") {
+		if root.Value.Type != TokenTypeObjectConst {
+			continue
+		}
+		for _, value := range GetObjectKeyValues(key, root.Value.ObjectConst) {
+			output = append(output, DataBag{Type: key, Value: value, Provenance: root.Provenance})
+		}
+	}
+	return output
+}
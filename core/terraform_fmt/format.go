@@ -105,7 +105,7 @@ func writeTerraform(ctx context.Context, subdir string, bags []core.DataBag) err
 		}
 	}
 
-	outputDir := ctx.Value("maker").(*core.Maker).OutputDir
+	outputDir := core.MakerFromContext(ctx).OutputDir
 	if subdir != "" {
 		outputDir = path.Join(outputDir, subdir)
 	}
@@ -568,6 +568,12 @@ func Visit(ctx context.Context, root *SyntaxToken, visitor Visitor) (*SyntaxToke
 		return root, err
 	}
 	if modifiedToken != nil {
+		//a token computed from a sensitive one is sensitive too, objects and arrays carry the mark on their items instead
+		if !IsSensitive(*modifiedToken) &&
+			(IsSensitive(*root) || (modifiedToken.Type != TokenTypeObjectConst && modifiedToken.Type != TokenTypeArrayConst && ContainsSensitive(*root))) {
+			marked := MarkSensitive(*modifiedToken)
+			modifiedToken = &marked
+		}
 		return modifiedToken, nil
 	}
 
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//VariableDatabagType declares an input variable, its value comes from --var, --var-file or its default, ex:
+//`variable "stage" { type = "string", default = "dev", validation { condition = contains(["dev", "prod"], var.stage), error_message = "..." } }`
+const VariableDatabagType = "variable"
+
+//variablesTraversalMap is the name of the traversal_map databag replacing the var.<name> traversals by their value
+const variablesTraversalMap = "barbe_variables"
+
+type VariableValidation struct {
+	Condition    SyntaxToken
+	ErrorMessage string
+}
+
+type VariableDeclaration struct {
+	Name        string
+	Type        SchemaAttributeType
+	Default     *SyntaxToken
+	Description string
+	Sensitive   bool
+	Validations []VariableValidation
+}
+
+func interpretVariableDeclaration(name string, token SyntaxToken) (VariableDeclaration, error) {
+	decl := VariableDeclaration{
+		Name: name,
+		Type: SchemaAttributeTypeAny,
+	}
+	attrs, err := extractBlockAttrs(token)
+	if err != nil {
+		return decl, err
+	}
+	for _, pair := range attrs {
+		switch pair.Key {
+		case "type":
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return decl, errors.Wrap(err, "error parsing 'type'")
+			}
+			if !contains(schemaAttributeTypes, str) {
+				return decl, fmt.Errorf("unknown type '%s', must be one of %s", str, strings.Join(schemaAttributeTypes, ", "))
+			}
+			decl.Type = str
+		case "default":
+			decl.Default = TokenPtr(pair.Value)
+		case "description":
+			str, err := ExtractAsStringValue(pair.Value)
+			if err != nil {
+				return decl, errors.Wrap(err, "error parsing 'description'")
+			}
+			decl.Description = str
+		case "sensitive":
+			sensitive, err := ExtractAsBool(pair.Value)
+			if err != nil {
+				return decl, errors.Wrap(err, "error parsing 'sensitive'")
+			}
+			decl.Sensitive = sensitive
+		case "validation":
+			validationAttrs := []SyntaxToken{pair.Value}
+			if pair.Value.Type == TokenTypeArrayConst {
+				validationAttrs = pair.Value.ArrayConst
+			}
+			for _, validationToken := range validationAttrs {
+				if validationToken.Type != TokenTypeObjectConst {
+					return decl, errors.New("'validation' must be a block")
+				}
+				validation := VariableValidation{}
+				conditions := GetObjectKeyValues("condition", validationToken.ObjectConst)
+				if len(conditions) != 1 {
+					return decl, errors.New("'validation' must have one 'condition'")
+				}
+				validation.Condition = conditions[0]
+				for _, msgToken := range GetObjectKeyValues("error_message", validationToken.ObjectConst) {
+					validation.ErrorMessage, err = ExtractAsStringValue(msgToken)
+					if err != nil {
+						return decl, errors.Wrap(err, "error parsing 'error_message'")
+					}
+				}
+				decl.Validations = append(decl.Validations, validation)
+			}
+		}
+	}
+	return decl, nil
+}
+
+//convertVariableValue gives its declared type to a value set with --var, which is always a string
+func convertVariableValue(value SyntaxToken, varType SchemaAttributeType) (SyntaxToken, error) {
+	str, ok := value.Value.(string)
+	if value.Type != TokenTypeLiteralValue || !ok {
+		return value, nil
+	}
+	switch varType {
+	case SchemaAttributeTypeNumber:
+		n, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return value, fmt.Errorf("'%s' is not a number", str)
+		}
+		return SyntaxToken{Type: TokenTypeLiteralValue, Value: n}, nil
+	case SchemaAttributeTypeBool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return value, fmt.Errorf("'%s' is not a bool", str)
+		}
+		return SyntaxToken{Type: TokenTypeLiteralValue, Value: b}, nil
+	case SchemaAttributeTypeList, SchemaAttributeTypeObject:
+		var v interface{}
+		err := json.Unmarshal([]byte(str), &v)
+		if err != nil {
+			return value, errors.Wrap(err, "a "+varType+" must be given as JSON")
+		}
+		return GoValueToToken(v)
+	}
+	return value, nil
+}
+
+//resolveVariables sets the value of every declared variable, checks their validations and inserts
+//the traversal_map that replaces var.<name> in the container. The variable databags get a 'value' attribute for the components
+func (maker *Maker) resolveVariables(ctx context.Context, container *ConfigContainer) error {
+	diags := Diagnostics{}
+	declared := map[string]bool{}
+	values := map[string]SyntaxToken{}
+	goValues := map[string]interface{}{}
+	type validationToCheck struct {
+		decl       VariableDeclaration
+		provenance Provenance
+	}
+	toCheck := make([]validationToCheck, 0)
+
+	names := mapKeys(container.DataBags[VariableDatabagType])
+	sort.Strings(names)
+	for _, name := range names {
+		for _, bag := range container.DataBags[VariableDatabagType][name] {
+			declared[name] = true
+			provenance := fileProvenance(bag.Provenance)
+			addError := func(attr string, summary string, detail string) {
+				d := Diagnostic{
+					Severity: DiagnosticSeverityError,
+					Summary:  summary,
+					Detail:   detail,
+					Range:    provenance.AttributeRange([]string{attr}),
+				}
+				if attr == "" {
+					d.Range = provenance.Range
+				}
+				diags = append(diags, d)
+			}
+
+			decl, err := interpretVariableDeclaration(name, bag.Value)
+			if err != nil {
+				addError("", "invalid variable '"+name+"'", err.Error())
+				continue
+			}
+			value, ok := maker.Variables[name]
+			if ok {
+				value, err = convertVariableValue(value, decl.Type)
+				if err != nil {
+					addError("", "invalid value for variable '"+name+"'", err.Error())
+					continue
+				}
+			} else if decl.Default != nil {
+				value = *decl.Default
+			} else {
+				addError("", "variable '"+name+"' is not set", "set it with --var "+name+"=..., --var-file or give it a default")
+				continue
+			}
+			if !tokenMatchesType(value, decl.Type) {
+				addError("type", "invalid value for variable '"+name+"'", "expected a "+decl.Type)
+				continue
+			}
+			if decl.Sensitive {
+				value = MarkSensitive(value)
+			}
+			goValue, err := TokenToGoValue(value, false)
+			if err != nil {
+				addError("", "invalid value for variable '"+name+"'", err.Error())
+				continue
+			}
+			values[name] = value
+			goValues[name] = normalizeValue(goValue)
+			toCheck = append(toCheck, validationToCheck{decl: decl, provenance: provenance})
+		}
+	}
+	for name := range maker.Variables {
+		if declared[name] {
+			continue
+		}
+		//not an error, a --var-file can be shared by directories that don't declare all of its variables
+		d := Diagnostic{
+			Severity: DiagnosticSeverityWarning,
+			Summary:  "variable '" + name + "' is set but not declared",
+			Detail:   "declare it with a 'variable \"" + name + "\" {}' block",
+		}
+		if closest := closestName(name, mapKeys(declared)); closest != "" {
+			d.Detail = "did you mean '" + closest + "'?"
+		}
+		diags = append(diags, d)
+	}
+
+	//validations run once all values are known, they can reference other variables
+	for _, check := range toCheck {
+		for i, validation := range check.decl.Validations {
+			r := check.provenance.AttributeRange([]string{fmt.Sprintf("validation[%d]", i), "condition"})
+			result, err := EvaluateExpression(validation.Condition, goValues)
+			if err != nil {
+				diags = append(diags, Diagnostic{
+					Severity: DiagnosticSeverityError,
+					Summary:  "error evaluating the validation of variable '" + check.decl.Name + "'",
+					Detail:   err.Error(),
+					Range:    r,
+				})
+				continue
+			}
+			valid, ok := result.(bool)
+			if !ok {
+				diags = append(diags, Diagnostic{
+					Severity: DiagnosticSeverityError,
+					Summary:  "the validation condition of variable '" + check.decl.Name + "' must be a bool",
+					Detail:   "got " + describeValue(result),
+					Range:    r,
+				})
+				continue
+			}
+			if !valid {
+				msg := validation.ErrorMessage
+				if msg == "" {
+					msg = "the value doesn't pass the validation condition"
+				}
+				diags = append(diags, Diagnostic{
+					Severity: DiagnosticSeverityError,
+					Summary:  "invalid value for variable '" + check.decl.Name + "'",
+					Detail:   msg,
+					Range:    r,
+				})
+			}
+		}
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+	for _, d := range diags {
+		log.Ctx(ctx).Warn().Msg(d.String())
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	traversalMap := map[string]SyntaxToken{}
+	for name, value := range values {
+		flattenVariable("var."+name, value, IsSensitive(value), traversalMap)
+		err := container.Insert(DataBag{
+			Type:   VariableDatabagType,
+			Name:   name,
+			Labels: []string{},
+			Value: SyntaxToken{
+				Type:        TokenTypeObjectConst,
+				ObjectConst: []ObjectConstItem{{Key: "value", Value: value}},
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "error inserting value of variable '"+name+"'")
+		}
+	}
+	mapToken := SyntaxToken{
+		Type:        TokenTypeObjectConst,
+		ObjectConst: make([]ObjectConstItem, 0, len(traversalMap)),
+	}
+	keys := mapKeys(traversalMap)
+	sort.Strings(keys)
+	for _, key := range keys {
+		mapToken.ObjectConst = append(mapToken.ObjectConst, ObjectConstItem{Key: key, Value: traversalMap[key]})
+	}
+	err := container.Insert(DataBag{
+		Type:   "traversal_map",
+		Name:   variablesTraversalMap,
+		Labels: []string{},
+		Value:  mapToken,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error inserting variables traversal map")
+	}
+	return nil
+}
+
+//flattenVariable maps the traversal of the variable and of everything in it, like var.tags.env or var.subnets[0]
+func flattenVariable(traversal string, value SyntaxToken, sensitive bool, output map[string]SyntaxToken) {
+	if sensitive && !IsSensitive(value) {
+		value = MarkSensitive(value)
+	}
+	output[traversal] = value
+	switch value.Type {
+	case TokenTypeObjectConst:
+		for _, pair := range value.ObjectConst {
+			flattenVariable(traversal+"."+pair.Key, pair.Value, sensitive, output)
+		}
+	case TokenTypeArrayConst:
+		for i, item := range value.ArrayConst {
+			flattenVariable(fmt.Sprintf("%s[%d]", traversal, i), item, sensitive, output)
+		}
+	}
+}
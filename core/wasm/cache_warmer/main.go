@@ -20,7 +20,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache))
+	compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache).WithCloseOnContextDone(true))
 	wasi_snapshot_preview1.MustInstantiate(ctx, compiledRuntime)
 
 	_, err = compiledRuntime.CompileModule(ctx, spiderMonkey)
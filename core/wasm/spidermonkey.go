@@ -1,12 +1,14 @@
 package wasm
 
 import (
+	"barbe/core"
 	"barbe/core/chown_util"
 	"barbe/core/version"
 	"bufio"
 	"context"
 	"embed"
 	_ "embed"
+	"github.com/docker/go-units"
 	"github.com/google/uuid"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
@@ -23,7 +25,18 @@ import (
 )
 
 const (
-	wazeroCachePath = "~/.cache/barbe/spidermonkey_for_" + version.Version
+	//the compiled code is cached by module hash only, code compiled without the termination checks
+	//(before components could be interrupted) must not be reused
+	wazeroCachePath = "~/.cache/barbe/spidermonkey_interruptible_for_" + version.Version
+	wasmPageSize    = 65536
+	//a component failing while its memory is this close to its limit is reported as exceeding the limit,
+	//the module only sees its allocations being refused
+	memoryLimitMargin = 4 * 1024 * 1024
+)
+
+var (
+	errTimeoutExceeded     = errors.New("timeout exceeded")
+	errMemoryLimitExceeded = errors.New("memory limit exceeded")
 )
 
 //https://spidermonkey.dev/
@@ -49,6 +62,16 @@ type SpiderMonkeyExecutor struct {
 	ctx        context.Context
 	cancel     context.CancelFunc
 	wgAllExecs sync.WaitGroup
+
+	cacheDir string
+	//the maximum memory of a module is set on the runtime, so there is one runtime per memory limit, keyed by number of pages
+	limitedRuntimes      map[uint32]limitedRuntime
+	limitedRuntimesMutex sync.Mutex
+}
+
+type limitedRuntime struct {
+	runtime          wazero.Runtime
+	spiderMonkeyCode wazero.CompiledModule
 }
 
 func cloneFs(logger zerolog.Logger, fromFs embed.FS, fromDir string, toDir string) error {
@@ -105,15 +128,17 @@ func NewSpiderMonkeyExecutor(logger zerolog.Logger) (*SpiderMonkeyExecutor, erro
 	ctx, cancel := context.WithCancel(context.Background())
 
 	exec := &SpiderMonkeyExecutor{
-		logger:     logger,
-		cancel:     cancel,
-		wgAllExecs: sync.WaitGroup{},
+		logger:          logger,
+		cancel:          cancel,
+		wgAllExecs:      sync.WaitGroup{},
+		limitedRuntimes: map[uint32]limitedRuntime{},
 	}
 
 	cacheDir, err := homedir.Expand(wazeroCachePath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to expand wazero cache path")
 	}
+	exec.cacheDir = cacheDir
 	err = os.MkdirAll(cacheDir, 0755)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create wazero cache dir")
@@ -128,7 +153,7 @@ func NewSpiderMonkeyExecutor(logger zerolog.Logger) (*SpiderMonkeyExecutor, erro
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to set wazero cache dir")
 		}
-		compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache))
+		compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache).WithCloseOnContextDone(true))
 		wasi_snapshot_preview1.MustInstantiate(ctx, compiledRuntime)
 
 		spiderMonkeyCompiled, err := compiledRuntime.CompileModule(ctx, spiderMonkey)
@@ -142,7 +167,7 @@ func NewSpiderMonkeyExecutor(logger zerolog.Logger) (*SpiderMonkeyExecutor, erro
 		return exec, nil
 	}
 
-	interpreter := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	interpreter := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter().WithCloseOnContextDone(true))
 	wasi_snapshot_preview1.MustInstantiate(ctx, interpreter)
 
 	//this takes a while but is way faster than the compiled version
@@ -162,7 +187,7 @@ func NewSpiderMonkeyExecutor(logger zerolog.Logger) (*SpiderMonkeyExecutor, erro
 			logger.Error().Err(err).Msg("failed to set wazero cache dir")
 			return
 		}
-		compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache))
+		compiledRuntime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache).WithCloseOnContextDone(true))
 		wasi_snapshot_preview1.MustInstantiate(ctx, compiledRuntime)
 
 		spiderMonkeyCompiled, err := compiledRuntime.CompileModule(ctx, spiderMonkey)
@@ -177,7 +202,45 @@ func NewSpiderMonkeyExecutor(logger zerolog.Logger) (*SpiderMonkeyExecutor, erro
 	return exec, nil
 }
 
-func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol, fileName string, jsContent []byte, input []byte, envVars map[string]string, state []byte) error {
+//runtimeWithMemoryLimit returns a runtime whose modules can't grow their memory past memoryLimit,
+//it uses the compilation cache so only the first component with a given limit pays for the compilation
+func (s *SpiderMonkeyExecutor) runtimeWithMemoryLimit(memoryLimit uint64) (wazero.Runtime, wazero.CompiledModule, error) {
+	//the limits given through the sdk aren't parsed by core.ParseComponentMemoryLimit
+	if memoryLimit < core.MinComponentMemoryLimit {
+		return nil, nil, errors.New("memory limit of " + units.BytesSize(float64(memoryLimit)) + " is below the minimum of " + units.BytesSize(core.MinComponentMemoryLimit))
+	}
+	pages := uint32(wasmPageSize)
+	if memoryLimit/wasmPageSize < wasmPageSize {
+		pages = uint32(memoryLimit / wasmPageSize)
+	}
+	s.limitedRuntimesMutex.Lock()
+	defer s.limitedRuntimesMutex.Unlock()
+	if limited, ok := s.limitedRuntimes[pages]; ok {
+		return limited.runtime, limited.spiderMonkeyCode, nil
+	}
+	cache, err := wazero.NewCompilationCacheWithDir(s.cacheDir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to set wazero cache dir")
+	}
+	runtime := wazero.NewRuntimeWithConfig(s.ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(cache).
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(pages))
+	wasi_snapshot_preview1.MustInstantiate(s.ctx, runtime)
+	spiderMonkeyCode, err := runtime.CompileModule(s.ctx, spiderMonkey)
+	if err != nil {
+		runtime.Close(s.ctx)
+		return nil, nil, errors.Wrap(err, "error compiling spidermonkey with a memory limit of "+units.BytesSize(float64(memoryLimit)))
+	}
+	s.limitedRuntimes[pages] = limitedRuntime{
+		runtime:          runtime,
+		spiderMonkeyCode: spiderMonkeyCode,
+	}
+	return runtime, spiderMonkeyCode, nil
+}
+
+//Execute returns errTimeoutExceeded or errMemoryLimitExceeded if the component was interrupted for exceeding one of its limits
+func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol, fileName string, jsContent []byte, input []byte, envVars map[string]string, state []byte, limits core.ComponentLimits) error {
 	s.wgAllExecs.Add(1)
 	defer s.wgAllExecs.Done()
 	fakeFs := semiRealFs{
@@ -202,6 +265,14 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 		}, nil
 	}
 
+	//the components see the sensitive values (ex: credentials), they must not end up in the logs
+	redact := func(s string) string {
+		return s
+	}
+	if maker := core.MakerFromContext(ctx); maker != nil {
+		redact = maker.RedactString
+	}
+
 	stdinReader, stdinWriter, err := os.Pipe()
 	if err != nil {
 		return errors.Wrap(err, "error creating stdin pipe")
@@ -248,6 +319,9 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 			select {
 			case <-s.ctx.Done():
 				closePipes()
+				//the reader stops on the closed pipe, and must not be left blocked on sending a line
+				for range lines {
+				}
 				return
 
 			case line, ok := <-lines:
@@ -260,13 +334,15 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 					continue
 				}
 				if len(resp) == 0 {
-					s.logger.Debug().Msg(strings.TrimSuffix(string(line), "\n"))
+					s.logger.Debug().Msg(redact(strings.TrimSuffix(string(line), "\n")))
 					//s.logger.Debug().Msgf("%s: %s", core.ContextScopeKey(ctx), string(line))
 					continue
 				}
 				_, err = stdinWriter.Write(append(resp, []byte("\n")...))
 				if err != nil {
-					s.logger.Error().Err(err).Msgf("error writing response to rpc function: %s", string(resp))
+					s.logger.Error().Err(err).Msgf("error writing response to rpc function: %s", redact(string(resp)))
+					for range lines {
+					}
 					return
 				}
 			}
@@ -276,10 +352,12 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 	config := wazero.NewModuleConfig().
 		WithStdin(stdinReader).
 		WithStdout(stdoutWriter).
-		WithStderr(os.Stderr).
+		WithStderr(redactingWriter{writer: os.Stderr, redact: redact}).
 		WithFS(fakeFs).
 		WithArgs("js", "-f", fileName).
-		WithName(uuid.NewString())
+		WithName(uuid.NewString()).
+		//started below, so the execution can be interrupted without waiting for it
+		WithStartFunctions()
 
 	for k, v := range envVars {
 		config = config.WithEnv(k, v)
@@ -291,6 +369,12 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 		runtime = s.wasmRuntimeCompiled
 		spiderMonkeyCode = s.spiderMonkeyCodeCompiled
 	}
+	if limits.MemoryLimit != 0 {
+		runtime, spiderMonkeyCode, err = s.runtimeWithMemoryLimit(limits.MemoryLimit)
+		if err != nil {
+			return err
+		}
+	}
 	if runtime == nil || spiderMonkeyCode == nil {
 		log.Ctx(ctx).Error().Msg("no runtime or spidermonkey code available. Trying to clear execution cache: `rm -rf ~/.cache/barbe`")
 		cacheDir, err := homedir.Expand(wazeroCachePath)
@@ -304,14 +388,66 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 		return errors.New("no runtime or spidermonkey code available. Execution cache cleared, please try again with correct permissions")
 	}
 
+	//the execution stops when either barbe or the caller is done with it
+	cancellableCtx, cancelExec := context.WithCancel(s.ctx)
+	defer cancelExec()
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelExec()
+		case <-cancellableCtx.Done():
+		}
+	}()
+	//the timeout is derived from the cancellable context, so the caller can still stop the execution
+	execCtx := cancellableCtx
+	if limits.Timeout != 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(execCtx, limits.Timeout)
+		defer cancel()
+	}
+
 	t := time.Now()
-	mod, err := runtime.InstantiateModule(s.ctx, spiderMonkeyCode, config)
+	mod, err := runtime.InstantiateModule(execCtx, spiderMonkeyCode, config)
+	if err != nil {
+		//fmt.Println("error:", err)
+		return errors.Wrap(err, "error instantiating module")
+	}
+	start := mod.ExportedFunction("_start")
+	if start == nil {
+		mod.Close(s.ctx)
+		return errors.New("no '_start' function exported by spidermonkey")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := start.Call(execCtx)
+		done <- err
+	}()
+
+	//the module is closed when the execution is cancelled, which interrupts it.
+	//We don't wait for the interrupted call to return, in case the module can't be interrupted
+	select {
+	case err = <-done:
+		if err != nil {
+			err = errors.Wrap(err, "error executing module")
+			if limits.MemoryLimit != 0 && mod.Memory() != nil && uint64(mod.Memory().Size())+memoryLimitMargin > limits.MemoryLimit {
+				err = errMemoryLimitExceeded
+			}
+		}
+	case <-execCtx.Done():
+		err = errors.Wrap(execCtx.Err(), "execution cancelled")
+		if errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+			err = errTimeoutExceeded
+		}
+	}
 	if os.Getenv("BARBE_VERBOSE") == "1" {
 		s.logger.Debug().Msgf("'%s' execution took, %s", fileName, time.Since(t))
 	}
 	if err != nil {
-		//fmt.Println("error:", err)
-		return errors.Wrap(err, "error instantiating module")
+		mod.CloseWithExitCode(s.ctx, 1)
+		//the logs goroutines stop on the closed pipes, they must be done before returning
+		closePipes()
+		wg.Wait()
+		return err
 	}
 	err = mod.Close(s.ctx)
 	if err != nil {
@@ -325,20 +461,42 @@ func (s *SpiderMonkeyExecutor) Execute(ctx context.Context, protocol RpcProtocol
 	return nil
 }
 
+//Close cancels the context of the executions still running, which makes the runtimes close their modules, and waits for them to return
 func (s *SpiderMonkeyExecutor) Close() error {
 	s.cancel()
 	s.wgAllExecs.Wait()
+	s.limitedRuntimesMutex.Lock()
+	for _, limited := range s.limitedRuntimes {
+		limited.spiderMonkeyCode.Close(s.ctx)
+		limited.runtime.Close(s.ctx)
+	}
+	s.limitedRuntimes = map[uint32]limitedRuntime{}
+	s.limitedRuntimesMutex.Unlock()
 	if s.spiderMonkeyCodeInterpreter != nil {
 		s.spiderMonkeyCodeInterpreter.Close(s.ctx)
 	}
 	if s.spiderMonkeyCodeCompiled != nil {
 		s.spiderMonkeyCodeCompiled.Close(s.ctx)
 	}
-	if s.spiderMonkeyCodeInterpreter != nil {
-		s.spiderMonkeyCodeInterpreter.Close(s.ctx)
+	if s.wasmRuntimeIntepreter != nil {
+		s.wasmRuntimeIntepreter.Close(s.ctx)
 	}
-	if s.spiderMonkeyCodeCompiled != nil {
-		s.spiderMonkeyCodeCompiled.Close(s.ctx)
+	if s.wasmRuntimeCompiled != nil {
+		s.wasmRuntimeCompiled.Close(s.ctx)
 	}
 	return nil
 }
+
+//redactingWriter hides the sensitive values from what the components write to stderr
+type redactingWriter struct {
+	writer io.Writer
+	redact func(string) string
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	_, err := w.writer.Write([]byte(w.redact(string(p))))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
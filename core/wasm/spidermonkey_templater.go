@@ -78,8 +78,17 @@ func (h *SpiderMonkeyTemplater) Name() string {
 	return "js_spidermonkey_templater"
 }
 
+//Close interrupts the executions still running, waits for them to return and releases the runtimes
+func (h *SpiderMonkeyTemplater) Close() error {
+	h.wg.Wait()
+	if h.executor == nil {
+		return nil
+	}
+	return h.executor.Close()
+}
+
 func (h *SpiderMonkeyTemplater) Apply(ctx context.Context, maker *core.Maker, input core.ConfigContainer, template fetcher.FileDescription) (core.ConfigContainer, error) {
-	if fetcher.ExtractExtension(template.Name) != ".js" {
+	if maker.FileExtension(template.Name) != ".js" {
 		return *core.NewConfigContainer(), nil
 	}
 	output := core.NewConfigContainer()
@@ -146,7 +155,14 @@ func (h *SpiderMonkeyTemplater) executeJs(ctx context.Context, maker *core.Maker
 		return errors.Wrap(err, "failed to marshal state object")
 	}
 
-	err = h.executor.Execute(ctx, protocol, path.Base(template.Name), template.Content, ctxObjJson, envVars, stateJson)
+	limits := maker.ComponentLimitsFor(template.Name)
+	err = h.executor.Execute(ctx, protocol, path.Base(template.Name), template.Content, ctxObjJson, envVars, stateJson, limits)
+	switch err {
+	case errTimeoutExceeded:
+		return core.NewComponentLimitError(template.Name, maker.CurrentStep, core.ComponentLimitTimeout, limits)
+	case errMemoryLimitExceeded:
+		return core.NewComponentLimitError(template.Name, maker.CurrentStep, core.ComponentLimitMemory, limits)
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to execute wasm for '"+template.Name+"'")
 	}
@@ -26,7 +26,7 @@ func transformContainerTemplate(ctx context.Context) RpcFunc {
 				return nil, err
 			}
 		}
-		maker := ctx.Value("maker").(*core.Maker)
+		maker := core.MakerFromContext(ctx)
 		newFromTransform, err := maker.Transform(ctx, *input)
 		if err != nil {
 			return nil, errors.Wrap(err, "error transforming container in pipeline")
@@ -74,7 +74,7 @@ func (h *WasmTemplater) Name() string {
 }
 
 func (h *WasmTemplater) Apply(ctx context.Context, maker *core.Maker, input core.ConfigContainer, template fetcher.FileDescription) (core.ConfigContainer, error) {
-	if fetcher.ExtractExtension(template.Name) != ".wasm" {
+	if maker.FileExtension(template.Name) != ".wasm" {
 		return *core.NewConfigContainer(), nil
 	}
 	output := core.NewConfigContainer()
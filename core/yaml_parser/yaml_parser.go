@@ -0,0 +1,339 @@
+package yaml_parser
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"barbe/core/hcl_parser"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//LabelsKey sets the labels of a databag, YAML has no equivalent to the extra labels of HCL blocks:
+//`aws_function: { api: { barbe_labels: ["v2"], ... } }` is the same as `aws_function "api" "v2" { ... }`
+const LabelsKey = "barbe_labels"
+
+//YamlParser maps the top level keys to databag types and the keys below them to databag names,
+//a top level key whose value isn't a mapping gives a databag with an empty name, like the JSON parser.
+//Strings containing ${...} are parsed as HCL templates so traversals and interpolations work as in .hcl files,
+//except in the files given with core.ContextWithLiteralFiles
+type YamlParser struct{}
+
+func (y YamlParser) Name() string {
+	return "yaml_parser"
+}
+
+func (y YamlParser) CanParse(ctx context.Context, fileDesc fetcher.FileDescription) (bool, error) {
+	l := core.MakerFromContext(ctx).FileExtension(fileDesc.Name)
+	return l == ".yaml" || l == ".yml", nil
+}
+
+func (y YamlParser) Parse(ctx context.Context, fileDesc fetcher.FileDescription, container *core.ConfigContainer) error {
+	var doc yaml.Node
+	err := yaml.Unmarshal(fileDesc.Content, &doc)
+	if err != nil {
+		return yamlErrorToDiagnostics(fileDesc, err)
+	}
+	//an empty file
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	p := parser{
+		file:    core.NewSourceFile(fileDesc.Name, fileDesc.Content),
+		literal: core.LiteralFilesFromContext(ctx),
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind == yaml.ScalarNode && root.ShortTag() == "!!null" {
+		return nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return p.diagnostic(root, "the top level of a yaml file must be a mapping", errors.New("expected a mapping of databag types"))
+	}
+
+	typePairs, err := p.mappingPairs(root)
+	if err != nil {
+		return err
+	}
+	for _, typePair := range typePairs {
+		typeName := typePair.key.Value
+		if typePair.value.Kind != yaml.MappingNode {
+			token, err := p.toToken(typePair.value)
+			if err != nil {
+				return p.diagnostic(typePair.key, "error parsing '"+typeName+"'", err)
+			}
+			bag := core.DataBag{
+				Name:       "",
+				Type:       typeName,
+				Labels:     []string{},
+				Value:      token,
+				Provenance: []core.Provenance{p.provenance(typePair.key, typePair.value)},
+			}
+			if err := container.Insert(bag); err != nil {
+				return errors.Wrap(err, "couldn't insert databag")
+			}
+			continue
+		}
+
+		namePairs, err := p.mappingPairs(typePair.value)
+		if err != nil {
+			return err
+		}
+		for _, namePair := range namePairs {
+			name := namePair.key.Value
+			labels, valueNode, err := p.extractLabels(namePair.value)
+			if err != nil {
+				return p.diagnostic(namePair.key, "error parsing '"+typeName+"."+name+"'", err)
+			}
+			token, err := p.toToken(valueNode)
+			if err != nil {
+				return p.diagnostic(namePair.key, "error parsing '"+typeName+"."+name+"'", err)
+			}
+			bag := core.DataBag{
+				Name:       name,
+				Type:       typeName,
+				Labels:     labels,
+				Value:      token,
+				Provenance: []core.Provenance{p.provenance(namePair.key, valueNode)},
+			}
+			if err := container.Insert(bag); err != nil {
+				return errors.Wrap(err, "couldn't insert databag")
+			}
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	file core.SourceFile
+	//literal is set for the files given with core.ContextWithLiteralFiles
+	literal bool
+}
+
+type nodePair struct {
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+//mappingPairs returns the key/value pairs of a mapping in order, with the merge keys (<<) expanded.
+//Like in YAML, the keys written explicitly take precedence over the merged ones
+func (p parser) mappingPairs(node *yaml.Node) ([]nodePair, error) {
+	explicit := map[string]struct{}{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].ShortTag() != "!!merge" {
+			explicit[node.Content[i].Value] = struct{}{}
+		}
+	}
+	output := make([]nodePair, 0, len(node.Content)/2)
+	seen := map[string]struct{}{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if key.ShortTag() != "!!merge" {
+			if key.Kind != yaml.ScalarNode {
+				return nil, p.diagnostic(key, "invalid key", errors.New("keys must be strings"))
+			}
+			output = append(output, nodePair{key: key, value: value})
+			continue
+		}
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			m = resolveAlias(m)
+			if m.Kind != yaml.MappingNode {
+				return nil, p.diagnostic(key, "invalid merge", errors.New("only mappings can be merged"))
+			}
+			pairs, err := p.mappingPairs(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				if _, ok := explicit[pair.key.Value]; ok {
+					continue
+				}
+				//with several merged mappings, the first one wins
+				if _, ok := seen[pair.key.Value]; ok {
+					continue
+				}
+				seen[pair.key.Value] = struct{}{}
+				output = append(output, pair)
+			}
+		}
+	}
+	return output, nil
+}
+
+//extractLabels removes the LabelsKey from the value of a databag
+func (p parser) extractLabels(node *yaml.Node) ([]string, *yaml.Node, error) {
+	labels := []string{}
+	if node.Kind != yaml.MappingNode {
+		return labels, node, nil
+	}
+	pairs, err := p.mappingPairs(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	withoutLabels := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Tag:     node.Tag,
+		Line:    node.Line,
+		Column:  node.Column,
+		Content: make([]*yaml.Node, 0, len(node.Content)),
+	}
+	for _, pair := range pairs {
+		if pair.key.Value != LabelsKey {
+			withoutLabels.Content = append(withoutLabels.Content, pair.key, pair.value)
+			continue
+		}
+		labelNodes := []*yaml.Node{pair.value}
+		if pair.value.Kind == yaml.SequenceNode {
+			labelNodes = pair.value.Content
+		}
+		for _, labelNode := range labelNodes {
+			labelNode = resolveAlias(labelNode)
+			if labelNode.Kind != yaml.ScalarNode {
+				return nil, nil, errors.New("'" + LabelsKey + "' must be a string or a list of strings")
+			}
+			labels = append(labels, labelNode.Value)
+		}
+	}
+	return labels, withoutLabels, nil
+}
+
+func (p parser) toToken(node *yaml.Node) (core.SyntaxToken, error) {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		pairs, err := p.mappingPairs(node)
+		if err != nil {
+			return core.SyntaxToken{}, err
+		}
+		output := core.SyntaxToken{
+			Type:        core.TokenTypeObjectConst,
+			ObjectConst: make([]core.ObjectConstItem, 0, len(pairs)),
+		}
+		for _, pair := range pairs {
+			item, err := p.toToken(pair.value)
+			if err != nil {
+				return core.SyntaxToken{}, errors.Wrap(err, "error parsing key '"+pair.key.Value+"'")
+			}
+			output.ObjectConst = append(output.ObjectConst, core.ObjectConstItem{
+				Key:   pair.key.Value,
+				Value: item,
+			})
+		}
+		return output, nil
+
+	case yaml.SequenceNode:
+		output := core.SyntaxToken{
+			Type:       core.TokenTypeArrayConst,
+			ArrayConst: make([]core.SyntaxToken, 0, len(node.Content)),
+		}
+		for i, itemNode := range node.Content {
+			item, err := p.toToken(itemNode)
+			if err != nil {
+				return core.SyntaxToken{}, errors.Wrap(err, fmt.Sprintf("error parsing index %d", i))
+			}
+			output.ArrayConst = append(output.ArrayConst, item)
+		}
+		return output, nil
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!str":
+			if p.literal || !strings.Contains(node.Value, "${") {
+				return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: node.Value}, nil
+			}
+			start := core.SourcePos{Line: node.Line, Column: node.Column}
+			if node.Style == yaml.DoubleQuotedStyle || node.Style == yaml.SingleQuotedStyle {
+				start.Column++
+			}
+			return hcl_parser.ParseTemplateString(node.Value, p.file.Name, start)
+		case "!!null":
+			return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: nil}, nil
+		case "!!bool", "!!int", "!!float":
+			var v interface{}
+			err := node.Decode(&v)
+			if err != nil {
+				return core.SyntaxToken{}, errors.Wrap(err, "error decoding '"+node.Value+"'")
+			}
+			return core.GoValueToToken(v)
+		}
+		//timestamps, binary and custom tags are kept as written
+		return core.SyntaxToken{Type: core.TokenTypeLiteralValue, Value: node.Value}, nil
+	}
+	return core.SyntaxToken{}, fmt.Errorf("unexpected yaml node at line %d", node.Line)
+}
+
+//provenance locates the databag and its attributes, YAML nodes only have a start position
+func (p parser) provenance(keyNode *yaml.Node, valueNode *yaml.Node) core.Provenance {
+	return core.FileProvenance(p.file.Name, p.nodeRange(keyNode), valueNode, p.children)
+}
+
+//children locates the pairs of a mapping from their key and the items of a sequence
+func (p parser) children(node *yaml.Node) []core.NestedNode[*yaml.Node] {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		pairs, err := p.mappingPairs(node)
+		if err != nil {
+			return nil
+		}
+		output := make([]core.NestedNode[*yaml.Node], 0, len(pairs))
+		for _, pair := range pairs {
+			output = append(output, core.NestedNode[*yaml.Node]{
+				Key:   pair.key.Value,
+				Range: p.nodeRange(pair.key),
+				Node:  pair.value,
+			})
+		}
+		return output
+	case yaml.SequenceNode:
+		output := make([]core.NestedNode[*yaml.Node], 0, len(node.Content))
+		for i, item := range node.Content {
+			output = append(output, core.NestedNode[*yaml.Node]{
+				Index:  i,
+				IsItem: true,
+				Range:  p.nodeRange(item),
+				Node:   item,
+			})
+		}
+		return output
+	}
+	return nil
+}
+
+func (p parser) nodeRange(node *yaml.Node) *core.SourceRange {
+	pos := core.SourcePos{Line: node.Line, Column: node.Column}
+	return p.file.Range(pos, pos)
+}
+
+func (p parser) diagnostic(node *yaml.Node, summary string, err error) error {
+	return core.LocatedDiagnostics(p.nodeRange(node), summary, err)
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+func yamlErrorToDiagnostics(fileDesc fetcher.FileDescription, err error) error {
+	line := 0
+	if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		line, _ = strconv.Atoi(match[1])
+	}
+	if line == 0 {
+		return errors.Wrap(err, "failed to parse yaml")
+	}
+	pos := core.SourcePos{Line: line, Column: 1}
+	file := core.NewSourceFile(fileDesc.Name, fileDesc.Content)
+	return core.LocatedDiagnostics(file.Range(pos, pos), "failed to parse yaml", errors.New(strings.TrimPrefix(err.Error(), "yaml: ")))
+}
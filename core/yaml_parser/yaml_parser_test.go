@@ -0,0 +1,269 @@
+package yaml_parser
+
+import (
+	"barbe/core"
+	"barbe/core/fetcher"
+	"context"
+	"errors"
+	"testing"
+)
+
+func parse(content string) (*core.ConfigContainer, error) {
+	container := core.NewConfigContainer()
+	err := YamlParser{}.Parse(context.Background(), fetcher.FileDescription{Name: "test.yaml", Content: []byte(content)}, container)
+	return container, err
+}
+
+func attribute(t *testing.T, bag core.DataBag, key string) core.SyntaxToken {
+	t.Helper()
+	values := core.GetObjectKeyValues(key, bag.Value.ObjectConst)
+	if len(values) != 1 {
+		t.Fatalf("expected one '%s' attribute on %s.%s, got %d", key, bag.Type, bag.Name, len(values))
+	}
+	return values[0]
+}
+
+func single(t *testing.T, container *core.ConfigContainer, bagType string, name string) core.DataBag {
+	t.Helper()
+	bags := container.GetDataBagGroup(bagType, name)
+	if len(bags) != 1 {
+		t.Fatalf("expected a single %s.%s, got %d", bagType, name, len(bags))
+	}
+	return bags[0]
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, container *core.ConfigContainer)
+	}{
+		{
+			name:    "empty document",
+			content: "",
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				if !container.IsEmpty() {
+					t.Errorf("expected no databag, got %v", container.DataBags)
+				}
+			},
+		},
+		{
+			name:    "null document",
+			content: "# only a comment\n~\n",
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				if !container.IsEmpty() {
+					t.Errorf("expected no databag, got %v", container.DataBags)
+				}
+			},
+		},
+		{
+			name: "labels",
+			content: `
+aws_function:
+  api:
+    barbe_labels: [v2, eu]
+    handler: index.handler
+  worker:
+    barbe_labels: v1
+  plain:
+    handler: worker.handler
+`,
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				api := single(t, container, "aws_function", "api")
+				if len(api.Labels) != 2 || api.Labels[0] != "v2" || api.Labels[1] != "eu" {
+					t.Errorf("expected the labels [v2 eu], got %v", api.Labels)
+				}
+				if len(core.GetObjectKeyValues(LabelsKey, api.Value.ObjectConst)) != 0 {
+					t.Errorf("'%s' must be removed from the value", LabelsKey)
+				}
+				attribute(t, api, "handler")
+				if worker := single(t, container, "aws_function", "worker"); len(worker.Labels) != 1 || worker.Labels[0] != "v1" {
+					t.Errorf("expected the label v1, got %v", worker.Labels)
+				}
+				if plain := single(t, container, "aws_function", "plain"); len(plain.Labels) != 0 {
+					t.Errorf("expected no label, got %v", plain.Labels)
+				}
+			},
+		},
+		{
+			name: "top level values",
+			content: `
+region: us-east-1
+subnets: [a, b]
+`,
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				if region := single(t, container, "region", ""); region.Value.Value != "us-east-1" {
+					t.Errorf("expected 'us-east-1', got %+v", region.Value)
+				}
+				if subnets := single(t, container, "subnets", ""); subnets.Value.Type != core.TokenTypeArrayConst || len(subnets.Value.ArrayConst) != 2 {
+					t.Errorf("expected the subnets array, got %+v", subnets.Value)
+				}
+			},
+		},
+		{
+			name: "merges and aliases",
+			content: `
+defaults:
+  base: &base
+    memory: 128
+    timeout: 10
+  extra: &extra
+    timeout: 30
+    runtime: nodejs
+aws_function:
+  api:
+    <<: [*base, *extra]
+    memory: 512
+    tags: &tags { team: api }
+  worker:
+    <<: *base
+    tags: *tags
+`,
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				api := single(t, container, "aws_function", "api")
+				//the explicit keys win over the merged ones, then the first merged mapping wins
+				if memory := attribute(t, api, "memory"); memory.Value != 512 {
+					t.Errorf("expected the explicit memory 512, got %+v", memory)
+				}
+				if timeout := attribute(t, api, "timeout"); timeout.Value != 10 {
+					t.Errorf("expected the timeout 10 of the first merged mapping, got %+v", timeout)
+				}
+				if runtime := attribute(t, api, "runtime"); runtime.Value != "nodejs" {
+					t.Errorf("expected the runtime of the second merged mapping, got %+v", runtime)
+				}
+				worker := single(t, container, "aws_function", "worker")
+				if memory := attribute(t, worker, "memory"); memory.Value != 128 {
+					t.Errorf("expected the merged memory 128, got %+v", memory)
+				}
+				tags := attribute(t, worker, "tags")
+				if tags.Type != core.TokenTypeObjectConst || len(core.GetObjectKeyValues("team", tags.ObjectConst)) != 1 {
+					t.Errorf("expected the aliased tags, got %+v", tags)
+				}
+			},
+		},
+		{
+			name: "templates",
+			content: `
+aws_function:
+  api:
+    name: api-${var.stage}
+    role: "${aws_iam_role.api.arn}"
+    handler: index.handler
+`,
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				api := single(t, container, "aws_function", "api")
+				name := attribute(t, api, "name")
+				if name.Type != core.TokenTypeTemplate || len(name.Parts) != 2 || name.Parts[1].Type != core.TokenTypeScopeTraversal {
+					t.Errorf("expected a template with a traversal, got %+v", name)
+				}
+				role := attribute(t, api, "role")
+				if role.Type != core.TokenTypeScopeTraversal || len(role.Traversal) != 3 || role.Traversal[0].Name == nil || *role.Traversal[0].Name != "aws_iam_role" {
+					t.Errorf("expected the traversal aws_iam_role.api.arn, got %+v", role)
+				}
+				if handler := attribute(t, api, "handler"); handler.Type != core.TokenTypeLiteralValue || handler.Value != "index.handler" {
+					t.Errorf("expected the literal 'index.handler', got %+v", handler)
+				}
+			},
+		},
+		{
+			name: "typed scalars",
+			content: `
+config:
+  main:
+    enabled: true
+    count: 3
+    ratio: 0.5
+    nothing: null
+    quoted: "3"
+    date: 2024-01-01
+`,
+			check: func(t *testing.T, container *core.ConfigContainer) {
+				main := single(t, container, "config", "main")
+				expected := map[string]interface{}{
+					"enabled": true,
+					"count":   3,
+					"ratio":   0.5,
+					"nothing": nil,
+					"quoted":  "3",
+					"date":    "2024-01-01",
+				}
+				for key, value := range expected {
+					if token := attribute(t, main, key); token.Value != value {
+						t.Errorf("expected %s to be %#v, got %#v", key, value, token.Value)
+					}
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			container, err := parse(test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, container)
+		})
+	}
+}
+
+func TestParseErrorLocations(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		column  int
+	}{
+		{
+			name:    "syntax error",
+			content: "aws_function:\n  api:\n    handler: a: b\n",
+			line:    3,
+			column:  1,
+		},
+		{
+			name:    "top level sequence",
+			content: "- a\n- b\n",
+			line:    1,
+			column:  1,
+		},
+		{
+			name:    "invalid labels",
+			content: "aws_function:\n  api:\n    barbe_labels: { a: b }\n",
+			line:    2,
+			column:  3,
+		},
+		{
+			name:    "merge of a scalar",
+			content: "aws_function:\n  api:\n    <<: 1\n",
+			line:    3,
+			column:  5,
+		},
+		{
+			name:    "invalid template",
+			content: "aws_function:\n  api:\n    name: api-${var.}\n",
+			line:    3,
+			column:  21,
+		},
+		{
+			name:    "invalid template in a quoted string",
+			content: "aws_function:\n  api:\n    name: \"api-${var.}\"\n",
+			line:    3,
+			column:  22,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse(test.content)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var diags core.Diagnostics
+			if !errors.As(err, &diags) || len(diags) == 0 || diags[0].Range == nil {
+				t.Fatalf("expected located diagnostics, got %s", err)
+			}
+			start := diags[0].Range.Start
+			if start.Line != test.line || start.Column != test.column {
+				t.Errorf("expected the error at %d:%d, got %d:%d (%s)", test.line, test.column, start.Line, start.Column, err)
+			}
+		})
+	}
+}
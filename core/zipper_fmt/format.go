@@ -35,9 +35,13 @@ func (t ZipperFormatter) Format(ctx context.Context, data core.ConfigContainer)
 }
 
 func applyZipper(ctx context.Context, databag core.DataBag) error {
-	wd, err := os.Getwd()
-	if err != nil {
-		return errors.Wrap(err, "error getting current working directory")
+	wd := core.MakerFromContext(ctx).BaseDir
+	if wd == "" {
+		var err error
+		wd, err = os.Getwd()
+		if err != nil {
+			return errors.Wrap(err, "error getting current working directory")
+		}
 	}
 
 	if databag.Value.Type != core.TokenTypeObjectConst {
@@ -48,7 +52,7 @@ func applyZipper(ctx context.Context, databag core.DataBag) error {
 	outputFiles := map[string]struct{}{}
 	includePatterns := map[string]struct{}{}
 	excludePatterns := map[string]struct{}{}
-	outputDir := ctx.Value("maker").(*core.Maker).OutputDir
+	outputDir := core.MakerFromContext(ctx).OutputDir
 
 	for _, pair := range databag.Value.ObjectConst {
 		switch pair.Key {
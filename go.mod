@@ -7,6 +7,7 @@ replace github.com/docker/docker => github.com/docker/docker v20.10.3-0.20220414
 require (
 	cloud.google.com/go/storage v1.22.1
 	cuelang.org/go v0.4.3
+	filippo.io/age v1.0.0
 	github.com/Microsoft/go-winio v0.5.2
 	github.com/aws/aws-sdk-go v1.44.98
 	github.com/charmbracelet/lipgloss v0.6.0
@@ -14,6 +15,7 @@ require (
 	github.com/containerd/containerd v1.6.14
 	github.com/docker/cli v23.0.0-rc.1+incompatible
 	github.com/docker/distribution v2.8.1+incompatible
+	github.com/docker/go-units v0.5.0
 	github.com/go-git/go-git/v5 v5.4.2
 	github.com/gofrs/flock v0.8.1
 	github.com/google/go-jsonnet v0.18.0
@@ -46,6 +48,7 @@ require (
 	golang.org/x/term v0.4.0
 	golang.org/x/time v0.1.0
 	google.golang.org/grpc v1.50.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -63,7 +66,6 @@ require (
 	github.com/docker/docker v23.0.0-rc.1+incompatible // indirect
 	github.com/docker/docker-credential-helpers v0.7.0 // indirect
 	github.com/docker/go-connections v0.4.0 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/emicklei/proto v1.11.0 // indirect
 	github.com/emirpasic/gods v1.12.0 // indirect
 	github.com/fsnotify/fsnotify v1.5.4 // indirect
@@ -132,6 +134,5 @@ require (
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	sigs.k8s.io/yaml v1.2.0 // indirect
 )
@@ -0,0 +1,207 @@
+//Package sdk runs barbe from Go code, without going through the CLI.
+//Every run gets its own Maker, so independent runs in the same process only share the caches on disk
+package sdk
+
+import (
+	"barbe/core"
+	"barbe/core/aws_session_provider"
+	"barbe/core/buildkit_runner"
+	"barbe/core/fetcher"
+	"barbe/core/gcp_token_provider"
+	"barbe/core/hcl_parser"
+	"barbe/core/import_component"
+	"barbe/core/json_parser"
+	"barbe/core/jsonnet_templater"
+	"barbe/core/raw_file"
+	"barbe/core/secret_provider"
+	"barbe/core/simplifier_transform"
+	"barbe/core/state_display"
+	"barbe/core/terraform_fmt"
+	"barbe/core/traversal_manipulator"
+	"barbe/core/wasm"
+	"barbe/core/yaml_parser"
+	"barbe/core/zipper_fmt"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const DefaultOutputDir = "barbe_dist"
+
+type Options struct {
+	//Command is core.MakeCommandGenerate (default), core.MakeCommandApply or core.MakeCommandDestroy
+	Command core.MakeCommand
+	//BaseDir is the directory relative paths are resolved against: OutputDir, PluginsDir, the local files fetched,
+	//the plugins declared in template blocks and the build context of containers. Defaults to the working directory of the process,
+	//concurrent runs for different directories should each set it instead of changing the working directory
+	BaseDir string
+	//OutputDir is where the formatters write the generated files, defaults to DefaultOutputDir
+	OutputDir string
+	//Env is the environment exposed to the components, nothing from the process environment is exposed by default
+	Env map[string]string
+	//ComponentLimits apply to every component, unless overridden by a component_limits block
+	ComponentLimits core.ComponentLimits
+	//Variables are the values of the variable blocks, string values are converted to the declared type of the variable
+	Variables map[string]core.SyntaxToken
+	//DisableComponentCache always executes the components instead of reusing their output from a previous run
+	DisableComponentCache bool
+	//PluginsDir is a directory of plugin executables to load, no plugin is loaded if empty
+	PluginsDir string
+	//DryRun records the containers that would run instead of running them, and doesn't persist the state
+	DryRun bool
+	//Fetcher can be given to configure a lock or url transformers, defaults to fetcher.NewFetcher()
+	Fetcher *fetcher.Fetcher
+	//StateDisplay receives the progress of the lifecycle steps, defaults to a new one
+	StateDisplay *state_display.StateDisplay
+}
+
+type Result struct {
+	Container *core.ConfigContainer
+	//Files are the paths of the files in the output directory once the run is done
+	Files []string
+}
+
+//NewMaker creates a maker with the same parsers, templaters, transformers and formatters as the CLI.
+//The caller must call Close on the maker once done with it
+func NewMaker(ctx context.Context, opts Options) (*core.Maker, error) {
+	command := opts.Command
+	if command == "" {
+		command = core.MakeCommandGenerate
+	}
+	mFetcher := opts.Fetcher
+	if mFetcher == nil {
+		mFetcher = fetcher.NewFetcher()
+	}
+	if opts.BaseDir != "" {
+		mFetcher.BaseDir = opts.BaseDir
+	}
+	maker := core.NewMaker(command, mFetcher)
+	maker.BaseDir = opts.BaseDir
+	maker.OutputDir = opts.OutputDir
+	if maker.OutputDir == "" {
+		maker.OutputDir = DefaultOutputDir
+	}
+	maker.OutputDir = maker.ResolvePath(maker.OutputDir)
+	if opts.StateDisplay != nil {
+		maker.StateDisplay = opts.StateDisplay
+	}
+	maker.Parsers = []core.Parser{
+		hcl_parser.HclParser{},
+		json_parser.JsonParser{},
+		yaml_parser.YamlParser{},
+	}
+	maker.Templaters = []core.TemplateEngine{
+		//hcl_templater.HclTemplater{},
+		//cue_templater.CueTemplater{},
+		jsonnet_templater.JsonnetTemplater{},
+		wasm.NewWasmTemplater(*zerolog.Ctx(ctx)),
+		wasm.NewSpiderMonkeyTemplater(*zerolog.Ctx(ctx)),
+	}
+	maker.Transformers = DefaultTransformers()
+	maker.Formatters = []core.Formatter{
+		terraform_fmt.TerraformFormatter{},
+		zipper_fmt.ZipperFormatter{},
+		raw_file.RawFileFormatter{},
+	}
+	maker.Env = opts.Env
+	if maker.Env == nil {
+		maker.Env = map[string]string{}
+	}
+	maker.ComponentLimits = opts.ComponentLimits
+	maker.Variables = opts.Variables
+	maker.DryRun = opts.DryRun
+
+	if opts.PluginsDir != "" {
+		plugins, err := core.FindPlugins(maker.ResolvePath(opts.PluginsDir))
+		if err != nil {
+			maker.Close()
+			return nil, err
+		}
+		for _, pluginPath := range plugins {
+			err = maker.LoadPlugin(ctx, pluginPath)
+			if err != nil {
+				maker.Close()
+				return nil, err
+			}
+		}
+	}
+	if !opts.DisableComponentCache {
+		var err error
+		maker.ComponentCache, err = core.NewComponentCache()
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("component cache disabled")
+		}
+	}
+	return maker, nil
+}
+
+//DefaultTransformers is separate from NewMaker because some transformers hold state from one run to the next,
+//a maker that is Reset to run again should get new ones
+func DefaultTransformers() []core.Transformer {
+	return []core.Transformer{
+		//the simplifier being first is very important, it simplifies syntax that is equivalent
+		//to make it a lot easier for the transformers to work with
+		simplifier_transform.SimplifierTransformer{},
+		traversal_manipulator.NewTraversalManipulator(),
+		aws_session_provider.AwsSessionProviderTransformer{},
+		gcp_token_provider.GcpTokenProviderTransformer{},
+		secret_provider.NewSecretProviderTransformer(),
+		raw_file.RawFileFormatter{},
+		buildkit_runner.NewBuildkitRunner(),
+		import_component.NewComponentImporter(),
+	}
+}
+
+//Run runs the command of opts over the given files, like `barbe generate/apply/destroy` does for one directory.
+//The container is returned even if the run failed, with what was produced until the failure
+func Run(ctx context.Context, opts Options, files []fetcher.FileDescription) (Result, error) {
+	maker, err := NewMaker(ctx, opts)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "failed to create maker")
+	}
+	defer maker.Close()
+	ctx = core.ContextWithMaker(ctx, maker)
+
+	err = os.MkdirAll(maker.OutputDir, 0755)
+	if err != nil {
+		return Result{}, errors.Wrapf(err, "failed to create output dir %s", maker.OutputDir)
+	}
+	container, err := maker.Make(ctx, files)
+	result := Result{
+		Container: container,
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Files, err = listFiles(maker.OutputDir)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func listFiles(dir string) ([]string, error) {
+	output := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			output = append(output, path)
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return output, nil
+		}
+		return nil, errors.Wrap(err, "error listing generated files")
+	}
+	sort.Strings(output)
+	return output, nil
+}